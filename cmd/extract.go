@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/stackrox/image-prefetcher/internal/workloads"
+
+	"github.com/spf13/cobra"
+)
+
+// extractImagesCmd represents the extract-images command
+var extractImagesCmd = &cobra.Command{
+	Use:   "extract-images [manifest-file...]",
+	Short: "Print images used by workloads in Kubernetes manifests.",
+	Long: `This subcommand reads multi-document Kubernetes YAML or JSON manifests, from the given files or standard input,
+and prints an image list suitable for fetch, with one image per line.
+
+Images are taken from pod templates of Pods, Deployments, ReplicaSets, StatefulSets, DaemonSets, Jobs, CronJobs,
+ReplicationControllers and PodTemplates: containers, init containers, ephemeral containers and image volumes.
+Other objects are ignored.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			args = []string{"-"}
+		}
+		images, err := workloads.FromManifestFiles(args...)
+		if err != nil {
+			return err
+		}
+		for _, image := range images {
+			if _, err := fmt.Fprintln(cmd.OutOrStdout(), image); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(extractImagesCmd)
+}
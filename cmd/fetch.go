@@ -1,12 +1,14 @@
 package cmd
 
 import (
-	"os"
-	"strings"
-	"time"
+	"context"
+	"fmt"
 
 	"github.com/stackrox/image-prefetcher/internal"
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+	"github.com/stackrox/image-prefetcher/internal/kube"
 	"github.com/stackrox/image-prefetcher/internal/logging"
+	"github.com/stackrox/image-prefetcher/internal/workloads"
 
 	"github.com/spf13/cobra"
 )
@@ -17,79 +19,108 @@ var fetchCmd = &cobra.Command{
 	Short: "Fetch images using CRI.",
 	Long: `This subcommand is intended to run in an init container of pods of a DaemonSet.
 
-It talks to Container Runtime Interface API to pull images in parallel, with retries.`,
+It talks to Container Runtime Interface API to pull images in parallel, with retries.
+Failures which retrying cannot fix, such as a missing image or rejected credentials, are not retried.
+If several credentials match an image, they are tried one after another, moving on only if the previous ones were rejected.
+The number of concurrent pulls can be bounded with --max-parallel-pulls, in which case remaining pulls wait in a queue.
+
+By default failed pulls are only reflected in the node label. With --fail-on, failed pulls make fetch exit with
+a code indicating the class of failures:
+  2  failures of different classes
+  3  image not found
+  4  invalid image reference
+  5  credentials rejected
+  6  digest mismatch
+  7  transient failures, such as timeouts or registry unavailability
+  8  unclassified failures
+  9  insufficient disk space
+
+With --dry-run, fetch plans pulls the same way, including credential lookups and checks for images already present,
+and prints the plan instead of pulling. Secrets are redacted. Nothing is reported: no metrics are submitted,
+and the node is not labeled.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		logger := logging.GetLogger()
-		timing := internal.TimingConfig{
-			ImageListTimeout:          imageListTimeout,
-			InitialPullAttemptTimeout: initialPullAttemptTimeout,
-			MaxPullAttemptTimeout:     maxPullAttemptTimeout,
-			OverallTimeout:            overallTimeout,
-			InitialPullAttemptDelay:   initialPullAttemptDelay,
-			MaxPullAttemptDelay:       maxPullAttemptDelay,
+		config, err := pullConfig()
+		if err != nil {
+			return err
 		}
-		imageList, err := loadImageNamesFromFile(imageListFile)
+		config.FailurePolicy, err = internal.ParseFailurePolicy(failOn, maxFailures)
 		if err != nil {
 			return err
 		}
-		imageList = append(imageList, args...)
-		return internal.Run(logger, criSocket, dockerConfigJSONPath, imageCredentialProviderConfig, imageCredentialProviderBinDir, timing, metricsEndpoint, imageList...)
+		planFormat, err := internal.ParsePlanFormat(dryRunOutput)
+		if err != nil {
+			return err
+		}
+		imageList, err := imagelist.LoadFile(imageListFile)
+		if err != nil {
+			return err
+		}
+		argImages, err := imagelist.ParseNames(args...)
+		if err != nil {
+			return err
+		}
+		imageList = imagelist.Merge(imageList, argImages...)
+		manifestImages, err := workloads.FromManifestFiles(manifestFiles...)
+		if err != nil {
+			return err
+		}
+		imageList = imagelist.Merge(imageList, imagelist.FromNames(manifestImages...)...)
+		if discoverWorkloads {
+			discoveredImages, err := discoverWorkloadImages(cmd.Context())
+			if err != nil {
+				return err
+			}
+			imageList = imagelist.Merge(imageList, imagelist.FromNames(discoveredImages...)...)
+		}
+		// Errors past this point are not caused by wrong usage.
+		cmd.SilenceUsage = true
+		if dryRun {
+			return internal.Plan(logger, config, cmd.OutOrStdout(), planFormat, imageList...)
+		}
+		return internal.Run(logger, config, imageList...)
 	},
 }
 
+func discoverWorkloadImages(ctx context.Context) ([]string, error) {
+	client, err := kube.NewClientset()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client for workload discovery: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, imageListTimeout)
+	defer cancel()
+	images, err := workloads.Discover(ctx, client, discoverNamespaces, discoverLabelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover workload images: %w", err)
+	}
+	return images, nil
+}
+
 var (
-	criSocket                        string
-	dockerConfigJSONPath             string
-	imageListFile                    string
-	metricsEndpoint                  string
-	imageCredentialProviderConfig    string
-	imageCredentialProviderBinDir    string
-	imageListTimeout                 = time.Minute
-	initialPullAttemptTimeout        = 30 * time.Second
-	maxPullAttemptTimeout            = 5 * time.Minute
-	overallTimeout                   = 20 * time.Minute
-	initialPullAttemptDelay          = time.Second
-	maxPullAttemptDelay              = 10 * time.Minute
+	imageListFile         string
+	manifestFiles         []string
+	discoverWorkloads     bool
+	discoverNamespaces    []string
+	discoverLabelSelector string
+	failOn                string
+	maxFailures           int
+	dryRun                bool
+	dryRunOutput          = string(internal.PlanFormatTable)
 )
 
 func init() {
 	rootCmd.AddCommand(fetchCmd)
 	logging.AddFlags(fetchCmd.Flags())
+	addPullFlags(fetchCmd.Flags())
 
-	fetchCmd.Flags().StringVar(&criSocket, "cri-socket", "/run/containerd/containerd.sock", "Path to CRI UNIX socket.")
-	fetchCmd.Flags().StringVar(&dockerConfigJSONPath, "docker-config", "", "Path to docker config json file.")
-	fetchCmd.Flags().StringVar(&imageListFile, "image-list-file", "", "Path to text file containing images to pull (one per line).")
-	fetchCmd.Flags().StringVar(&metricsEndpoint, "metrics-endpoint", "", "A host:port to submit image pull metrics to.")
-	fetchCmd.Flags().StringVar(&imageCredentialProviderConfig, "image-credential-provider-config", "", "Path to credential provider plugin config file.")
-	fetchCmd.Flags().StringVar(&imageCredentialProviderBinDir, "image-credential-provider-bin-dir", "", "Path to credential provider plugin binary directory.")
-
-	fetchCmd.Flags().DurationVar(&imageListTimeout, "image-list-timeout", imageListTimeout, "Timeout for image list calls (for debugging).")
-	fetchCmd.Flags().DurationVar(&initialPullAttemptTimeout, "initial-pull-attempt-timeout", initialPullAttemptTimeout, "Timeout for initial image pull call. Each subsequent attempt doubles it until max.")
-	fetchCmd.Flags().DurationVar(&maxPullAttemptTimeout, "max-pull-attempt-timeout", maxPullAttemptTimeout, "Maximum timeout for image pull call.")
-	fetchCmd.Flags().DurationVar(&overallTimeout, "overall-timeout", overallTimeout, "Overall timeout for a single run.")
-	fetchCmd.Flags().DurationVar(&initialPullAttemptDelay, "initial-pull-attempt-delay", initialPullAttemptDelay, "Timeout for initial delay between pulls of the same image. Each subsequent attempt doubles it until max.")
-	fetchCmd.Flags().DurationVar(&maxPullAttemptDelay, "max-pull-attempt-delay", maxPullAttemptDelay, "Maximum delay between pulls of the same image.")
-}
-
-func loadImageNamesFromFile(fileName string) ([]string, error) {
-	if fileName == "" {
-		return nil, nil
-	}
-	bytes, err := os.ReadFile(fileName)
-	if err != nil {
-		return nil, err
-	}
-	return parseImageNames(bytes), nil
-}
-
-func parseImageNames(bytes []byte) []string {
-	var imageNames []string
-	for _, line := range strings.Split(string(bytes), "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		imageNames = append(imageNames, line)
-	}
-	return imageNames
+	fetchCmd.Flags().StringVar(&imageListFile, "image-list-file", "", "Path to file containing images to pull: either text with one image per line, optionally followed by key=value attributes, or YAML/JSON.")
+	fetchCmd.Flags().BoolVar(&discoverWorkloads, "discover-workloads", false, "Also pull images used by Deployments, StatefulSets, DaemonSets and CronJobs in the cluster. Requires permission to list them.")
+	fetchCmd.Flags().StringSliceVar(&discoverNamespaces, "discover-namespaces", nil, "Namespaces to discover workloads in. Empty means all namespaces.")
+	fetchCmd.Flags().StringVar(&discoverLabelSelector, "discover-label-selector", "", "Label selector restricting discovered workloads, such as team=a.")
+	fetchCmd.Flags().StringSliceVar(&manifestFiles, "manifests", nil, "Paths to Kubernetes manifest files to pull images of workloads from, in addition to the image list. - means standard input.")
+	fetchCmd.Flags().StringVar(&failOn, "fail-on", string(internal.FailOnNever), "Which failed images make fetch exit with an error: "+
+		"any (all images except those marked required=false in the image list), required (only images marked required=true) or never.")
+	fetchCmd.Flags().IntVar(&maxFailures, "max-failures", 0, "Number of failed images counted by --fail-on which is still tolerated. Must not be negative.")
+	fetchCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the planned pulls, with normalized image names, credential sources and images already present, instead of pulling.")
+	fetchCmd.Flags().StringVar(&dryRunOutput, "output", dryRunOutput, "Output format of --dry-run: table or json.")
 }
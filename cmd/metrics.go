@@ -1,8 +1,13 @@
 package cmd
 
 import (
+	"net/http"
+	"time"
+
+	"github.com/stackrox/image-prefetcher/internal/credentialprovider"
 	"github.com/stackrox/image-prefetcher/internal/logging"
 	"github.com/stackrox/image-prefetcher/internal/metrics/server"
+	"github.com/stackrox/image-prefetcher/internal/tagresolver"
 
 	"github.com/spf13/cobra"
 )
@@ -15,15 +20,39 @@ var aggregateMetricsCmd = &cobra.Command{
 
 It serves:
 - a gRPC endpoint to which individual metrics can be submitted,
-- an HTTP endpoint from which the aggregate metrics can be fetched.`,
+- an HTTP endpoint from which the aggregate metrics can be fetched.
+
+With --resolve-tags, the gRPC endpoint also resolves image tags to digests for fetch and watch --resolve-tags.
+Resolved digests are cached for --resolved-tag-ttl, and concurrent requests for the same tag share a single request
+to its registry, so that all nodes of a rollout pull the same digest even if the tag is moved meanwhile.
+The TTL should cover the duration of a rollout. Credentials for registries are taken from --docker-config.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return server.Run(logging.GetLogger(), grpcPort, httpPort)
+		logger := logging.GetLogger()
+		var resolver *tagresolver.Resolver
+		if resolveTags {
+			keyring := &credentialprovider.BasicDockerKeyring{}
+			if aggregatorDockerConfigJSONPath != "" {
+				auths, err := credentialprovider.ReadDockerConfigJSONFile(aggregatorDockerConfigJSONPath)
+				if err != nil {
+					return err
+				}
+				keyring.Add(auths)
+			}
+			resolver = tagresolver.New(logger, &http.Client{Timeout: registryRequestTimeout}, keyring, resolvedTagTTL)
+		}
+		cmd.SilenceUsage = true
+		return server.Run(logger, grpcPort, httpPort, resolver)
 	},
 }
 
+// registryRequestTimeout bounds each request of tag resolution to a registry.
+const registryRequestTimeout = 30 * time.Second
+
 var (
-	grpcPort int
-	httpPort int
+	grpcPort                       int
+	httpPort                       int
+	aggregatorDockerConfigJSONPath string
+	resolvedTagTTL                 = 10 * time.Minute
 )
 
 func init() {
@@ -31,4 +60,7 @@ func init() {
 	logging.AddFlags(aggregateMetricsCmd.Flags())
 	aggregateMetricsCmd.Flags().IntVar(&grpcPort, "grpc-port", 8443, "Port for metrics submission gRPC endpoint to listen on.")
 	aggregateMetricsCmd.Flags().IntVar(&httpPort, "http-port", 8080, "Port for metrics retrieval HTTP endpoint to listen on.")
+	aggregateMetricsCmd.Flags().BoolVar(&resolveTags, "resolve-tags", false, "Serve resolution of image tags to digests.")
+	aggregateMetricsCmd.Flags().DurationVar(&resolvedTagTTL, "resolved-tag-ttl", resolvedTagTTL, "How long resolved digests are served before tags are resolved again. Zero disables caching.")
+	aggregateMetricsCmd.Flags().StringVar(&aggregatorDockerConfigJSONPath, "docker-config", "", "Path to docker config json file with credentials for resolving tags.")
 }
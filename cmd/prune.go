@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/stackrox/image-prefetcher/internal"
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+	"github.com/stackrox/image-prefetcher/internal/logging"
+
+	"github.com/spf13/cobra"
+)
+
+// pruneCmd represents the prune command
+var pruneCmd = &cobra.Command{
+	Use:   "prune [image...]",
+	Short: "Remove images pulled earlier which are no longer listed.",
+	Long: `This subcommand removes images which fetch or watch pulled on this node earlier, but which are no longer in the
+image list, using CRI. It is intended to be run in a pod of the DaemonSet, for example with kubectl exec, after
+images were removed from the image list.
+
+Images pulled by fetch and watch are recorded in a node annotation, per instance, so that only these images are
+removed. Images which are in use by any containers on the node are kept, as are images which are also pulled under
+a name which is still listed. With --dry-run, nothing is removed and the images which would be are printed instead.
+
+Requires NODE_NAME and INSTANCE_NAME environment variables, and permission to get and update the node.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := logging.GetLogger()
+		imageList, err := imagelist.LoadFile(imageListFile)
+		if err != nil {
+			return err
+		}
+		argImages, err := imagelist.ParseNames(args...)
+		if err != nil {
+			return err
+		}
+		imageList = imagelist.Merge(imageList, argImages...)
+		config := internal.PruneConfig{
+			CRISocketPath:   criSocket,
+			RuntimeHandlers: runtimeHandlers,
+			Timeout:         pruneTimeout,
+			DryRun:          pruneDryRun,
+		}
+		// Errors past this point are not caused by wrong usage.
+		cmd.SilenceUsage = true
+		return internal.Prune(logger, config, cmd.OutOrStdout(), imageList...)
+	},
+}
+
+var (
+	pruneTimeout = 5 * time.Minute
+	pruneDryRun  bool
+)
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+	logging.AddFlags(pruneCmd.Flags())
+
+	pruneCmd.Flags().StringVar(&criSocket, "cri-socket", "/run/containerd/containerd.sock", "Path to CRI UNIX socket.")
+	pruneCmd.Flags().StringVar(&imageListFile, "image-list-file", "", "Path to image list file, in the same format as for fetch. Images pulled earlier which are not listed in it or as arguments are removed.")
+	pruneCmd.Flags().StringSliceVar(&runtimeHandlers, "runtime-handlers", nil, "Comma-separated CRI runtime handlers for images which do not specify their own, as for fetch.")
+	pruneCmd.Flags().DurationVar(&pruneTimeout, "timeout", pruneTimeout, "Timeout for the whole prune.")
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "Only print which images would be removed.")
+}
@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/stackrox/image-prefetcher/internal"
+	"github.com/stackrox/image-prefetcher/internal/coordination"
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+	"github.com/stackrox/image-prefetcher/internal/registrylimits"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// pullConfig returns the configuration of pulls set by flags added with addPullFlags.
+func pullConfig() (internal.Config, error) {
+	timing := internal.TimingConfig{
+		ImageListTimeout:          imageListTimeout,
+		InitialPullAttemptTimeout: initialPullAttemptTimeout,
+		MaxPullAttemptTimeout:     maxPullAttemptTimeout,
+		OverallTimeout:            overallTimeout,
+		ReportingTimeout:          reportingTimeout,
+		TerminationGracePeriod:    terminationGracePeriod,
+		InitialPullAttemptDelay:   initialPullAttemptDelay,
+		MaxPullAttemptDelay:       maxPullAttemptDelay,
+	}
+	registryLimits, err := registrylimits.LoadConfigFile(registryLimitsFile, registrylimits.Limits{
+		MaxParallelPulls: registryMaxParallelPulls,
+		PullsPerMinute:   registryPullsPerMinute,
+	})
+	if err != nil {
+		return internal.Config{}, err
+	}
+	policy, err := imagelist.ParsePullPolicy(pullPolicy)
+	if err != nil {
+		return internal.Config{}, err
+	}
+	if resolveTags && metricsEndpoint == "" {
+		return internal.Config{}, errors.New("--resolve-tags requires --metrics-endpoint")
+	}
+	order, err := internal.ParsePullOrder(pullOrder)
+	if err != nil {
+		return internal.Config{}, err
+	}
+	minFreeBytes, err := parseMinFreeDisk(minFreeDisk)
+	if err != nil {
+		return internal.Config{}, err
+	}
+	if coordinationLeaseDuration < coordination.MinLeaseDuration {
+		return internal.Config{}, fmt.Errorf("--coordination-lease-duration must be at least %s", coordination.MinLeaseDuration)
+	}
+	if sandboxNamespace != "" {
+		if _, err := imagelist.ParseNamespace(sandboxNamespace); err != nil {
+			return internal.Config{}, err
+		}
+	}
+	return internal.Config{
+		CRISocketPath:            criSocket,
+		DockerConfigJSONPath:     dockerConfigJSONPath,
+		CredentialProviderConfig: imageCredentialProviderConfig,
+		CredentialProviderBinDir: imageCredentialProviderBinDir,
+		MetricsEndpoint:          metricsEndpoint,
+		Timing:                   timing,
+		MaxParallelPulls:         maxParallelPulls,
+		RegistryLimits:           registryLimits,
+		PullPolicy:               policy,
+		PullOrder:                order,
+		AnonymousFallback:        anonymousFallback,
+		ResolveTags:              resolveTags,
+		RuntimeHandlers:          runtimeHandlers,
+		Sandbox: internal.SandboxConfig{
+			Namespace:   sandboxNamespace,
+			Labels:      sandboxLabels,
+			Annotations: sandboxAnnotations,
+		},
+		Coordination: internal.CoordinationConfig{
+			Slots:         coordinationSlots,
+			LeaseDuration: coordinationLeaseDuration,
+			SlotTimeout:   coordinationSlotTimeout,
+		},
+		DiskSpace: internal.DiskSpaceConfig{
+			MinFreeBytes: minFreeBytes,
+			ImageFsPath:  imageFsPath,
+		},
+	}, nil
+}
+
+// parseMinFreeDisk parses the --min-free-disk quantity, such as 2Gi, into bytes. Empty means zero.
+func parseMinFreeDisk(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	quantity, err := resource.ParseQuantity(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --min-free-disk %q: %w", s, err)
+	}
+	if quantity.Sign() < 0 {
+		return 0, fmt.Errorf("invalid --min-free-disk %q: must not be negative", s)
+	}
+	return uint64(quantity.Value()), nil
+}
+
+var (
+	criSocket                     string
+	dockerConfigJSONPath          string
+	metricsEndpoint               string
+	imageCredentialProviderConfig string
+	imageCredentialProviderBinDir string
+	maxParallelPulls              int
+	pullPolicy                    string
+	pullOrder                     string
+	anonymousFallback             bool
+	resolveTags                   bool
+	runtimeHandlers               []string
+	sandboxNamespace              string
+	sandboxLabels                 map[string]string
+	sandboxAnnotations            map[string]string
+	registryMaxParallelPulls      int
+	registryPullsPerMinute        int
+	registryLimitsFile            string
+	coordinationSlots             int
+	minFreeDisk                   string
+	imageFsPath                   string
+	coordinationLeaseDuration     = 30 * time.Second
+	coordinationSlotTimeout       = time.Hour
+	imageListTimeout              = time.Minute
+	initialPullAttemptTimeout     = 30 * time.Second
+	maxPullAttemptTimeout         = 5 * time.Minute
+	overallTimeout                = 20 * time.Minute
+	reportingTimeout              = time.Minute
+	terminationGracePeriod        = 10 * time.Second
+	initialPullAttemptDelay       = time.Second
+	maxPullAttemptDelay           = 10 * time.Minute
+)
+
+// addPullFlags adds flags configuring how images are pulled, shared by subcommands which pull images.
+func addPullFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&criSocket, "cri-socket", "/run/containerd/containerd.sock", "Path to CRI UNIX socket.")
+	flags.StringVar(&dockerConfigJSONPath, "docker-config", "", "Path to docker config json file.")
+	flags.StringVar(&metricsEndpoint, "metrics-endpoint", "", "A host:port to submit image pull metrics to.")
+	flags.StringVar(&imageCredentialProviderConfig, "image-credential-provider-config", "", "Path to credential provider plugin config file.")
+	flags.StringVar(&imageCredentialProviderBinDir, "image-credential-provider-bin-dir", "", "Path to credential provider plugin binary directory.")
+	flags.StringVar(&pullPolicy, "pull-policy", string(imagelist.PullAlways), "Pull policy for images which do not specify one in the image list. One of Always, IfNotPresent.")
+	flags.StringVar(&pullOrder, "pull-order", string(internal.PullOrderPriority), "Order in which pulls are started, when their number is limited by --max-parallel-pulls. "+
+		"One of list (image list order, ignoring priorities), priority (higher priority first, then list order), "+
+		"smallest-first (higher priority first, then by size reported to the metrics endpoint by earlier runs) "+
+		"or random (higher priority first, then a random per-node order).")
+	flags.BoolVar(&anonymousFallback, "anonymous-fallback", false, "Whether to try pulling anonymously after all credentials found for an image were rejected. Anonymous pulls are always tried if no credentials are found.")
+	flags.BoolVar(&resolveTags, "resolve-tags", false, "Pull images by the digests their tags are resolved to once for all nodes by the metrics endpoint, which must run aggregate-metrics --resolve-tags. "+
+		"Images which cannot be resolved are pulled by tag.")
+	flags.StringSliceVar(&runtimeHandlers, "runtime-handlers", nil, "Comma-separated CRI runtime handlers (as in RuntimeClass handler) to pull images for, for images which do not specify their own. Each image is pulled once per handler. Empty means the default handler only.")
+	flags.StringVar(&sandboxNamespace, "sandbox-namespace", "", "Namespace of a synthetic pod sandbox config passed with pull requests, as kubelet does for real pods. If this and the two flags below are empty, no sandbox config is passed.")
+	flags.StringToStringVar(&sandboxLabels, "sandbox-labels", nil, "Labels of the synthetic pod sandbox config passed with pull requests.")
+	flags.StringToStringVar(&sandboxAnnotations, "sandbox-annotations", nil, "Annotations of the synthetic pod sandbox config passed with pull requests.")
+	flags.IntVar(&maxParallelPulls, "max-parallel-pulls", 0, "Maximum number of image pulls in flight at once. Zero means no limit.")
+	flags.IntVar(&registryMaxParallelPulls, "registry-max-parallel-pulls", 0, "Maximum number of image pulls in flight at once from a single registry. Zero means no limit.")
+	flags.IntVar(&registryPullsPerMinute, "registry-pulls-per-minute", 0, "Maximum rate of image pull attempts from a single registry. Zero means no limit.")
+	flags.StringVar(&registryLimitsFile, "registry-limits-file", "", "Path to YAML or JSON file with per-registry limits, overriding the two flags above for the listed registries.")
+	flags.IntVar(&coordinationSlots, "coordination-slots", 0, "Maximum number of nodes pulling at the same time, cluster-wide, coordinated using Lease objects. Zero disables coordination. Requires INSTANCE_NAME, NODE_NAME and POD_NAMESPACE environment variables.")
+	flags.DurationVar(&coordinationLeaseDuration, "coordination-lease-duration", coordinationLeaseDuration, "Duration after which a pull slot held by an unresponsive node can be taken over. At least 1s.")
+	flags.DurationVar(&coordinationSlotTimeout, "coordination-slot-timeout", coordinationSlotTimeout, "Maximum time to wait for a pull slot, after which pulls start without one. "+
+		"Does not count against --overall-timeout, which starts once a slot is held. Zero means no limit.")
+	flags.StringVar(&minFreeDisk, "min-free-disk", "", "Free space to leave on the image filesystem, such as 2Gi. Pulls which would leave less, judging by image sizes reported to the metrics endpoint by earlier runs, are skipped, "+
+		"lowest priority first, and pulls stop once free space drops below it. Empty disables the check. Requires the image filesystem to be visible, see --image-fs-path.")
+	flags.StringVar(&imageFsPath, "image-fs-path", "", "Path where the image filesystem of the runtime is visible, for measuring free space on it. Empty means the mount point reported by the runtime.")
+
+	flags.DurationVar(&imageListTimeout, "image-list-timeout", imageListTimeout, "Timeout for image list and status calls.")
+	flags.DurationVar(&initialPullAttemptTimeout, "initial-pull-attempt-timeout", initialPullAttemptTimeout, "Timeout for initial image pull call. Each subsequent attempt doubles it until max.")
+	flags.DurationVar(&maxPullAttemptTimeout, "max-pull-attempt-timeout", maxPullAttemptTimeout, "Maximum timeout for image pull call.")
+	flags.DurationVar(&overallTimeout, "overall-timeout", overallTimeout, "Timeout for pulling images in a single run, or a single batch of new images when watching. Reporting results afterwards is bounded by --reporting-timeout instead.")
+	flags.DurationVar(&reportingTimeout, "reporting-timeout", reportingTimeout, "Timeout for reporting results after pulling images finished or timed out: submitting metrics, labeling the node and listing images.")
+	flags.DurationVar(&terminationGracePeriod, "termination-grace-period", terminationGracePeriod, "Time left for submitting metrics and labeling the node after SIGTERM or SIGINT interrupted pulls. "+
+		"Should be shorter than the terminationGracePeriodSeconds of the pod.")
+	flags.DurationVar(&initialPullAttemptDelay, "initial-pull-attempt-delay", initialPullAttemptDelay, "Initial delay between pulls of the same image, randomized by +/-50%. Each subsequent attempt doubles it until max.")
+	flags.DurationVar(&maxPullAttemptDelay, "max-pull-attempt-delay", maxPullAttemptDelay, "Maximum delay between pulls of the same image.")
+}
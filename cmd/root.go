@@ -1,9 +1,11 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"github.com/spf13/cobra"
 	"log"
+	"os"
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -18,6 +20,11 @@ var rootCmd = &cobra.Command{
 // Execute is the entry point to this program.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
+		var exitCoder interface{ ExitCode() int }
+		if errors.As(err, &exitCoder) {
+			log.Print(err)
+			os.Exit(exitCoder.ExitCode())
+		}
 		log.Fatal(err)
 	}
 }
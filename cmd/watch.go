@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stackrox/image-prefetcher/internal"
+	"github.com/stackrox/image-prefetcher/internal/logging"
+
+	"github.com/spf13/cobra"
+)
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Fetch new images as they appear, until terminated.",
+	Long: `This subcommand is intended to run in the main container of pods of a DaemonSet, after fetch in the init container.
+
+With --image-list-file, it pulls images added to the image list, such as when the ConfigMap the file is mounted from
+changes, without pulling the images which were already listed again. Kubelet propagates ConfigMap changes to mounted
+files with a delay of up to a minute or so. Images which are listed under the same name, but with different attributes,
+are not pulled again.
+Listed images are also checked for presence every --reconcile-interval. Images found missing, for example because
+kubelet image garbage collection removed them, are pulled again, and the node label says missing until they are.
+
+With --watch-workloads, it watches Deployments, StatefulSets, DaemonSets and CronJobs, and pulls images which start
+being used by them, such as when a pod template image changes. This way images are usually present on nodes by the time
+pods of a rollout start. Images used when watch starts are not pulled, use fetch --discover-workloads for these.
+
+New images are pulled in batches, the same way as fetch does, with each batch bounded by --overall-timeout.
+After each batch, metrics are submitted and the node label set by fetch is updated. The label stays failed if fetch,
+or any batch so far, failed.
+Without any sources of new images enabled, this subcommand just waits for a termination signal.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := logging.GetLogger()
+		config, err := pullConfig()
+		if err != nil {
+			return err
+		}
+		if imageListPollInterval <= 0 {
+			return fmt.Errorf("--image-list-poll-interval must be positive, got %s", imageListPollInterval)
+		}
+		watchConfig := internal.WatchConfig{
+			Workloads:             watchWorkloads,
+			Namespaces:            watchNamespaces,
+			LabelSelector:         watchLabelSelector,
+			ImageListFile:         imageListFile,
+			ImageListPollInterval: imageListPollInterval,
+			ReconcileInterval:     reconcileInterval,
+			BatchDelay:            batchDelay,
+		}
+		// Errors past this point are not caused by wrong usage.
+		cmd.SilenceUsage = true
+		return internal.Watch(logger, config, watchConfig)
+	},
+}
+
+var (
+	watchWorkloads        bool
+	watchNamespaces       []string
+	watchLabelSelector    string
+	imageListPollInterval = 30 * time.Second
+	reconcileInterval     = 5 * time.Minute
+	batchDelay            = 5 * time.Second
+)
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	logging.AddFlags(watchCmd.Flags())
+	addPullFlags(watchCmd.Flags())
+
+	watchCmd.Flags().StringVar(&imageListFile, "image-list-file", "", "Path to image list file, in the same format as for fetch, to watch for added images.")
+	watchCmd.Flags().DurationVar(&imageListPollInterval, "image-list-poll-interval", imageListPollInterval, "How often the image list file is checked for changes.")
+	watchCmd.Flags().DurationVar(&reconcileInterval, "reconcile-interval", reconcileInterval, "How often images in the image list file are checked for presence, and pulled again if missing. Zero disables these checks.")
+	watchCmd.Flags().BoolVar(&watchWorkloads, "watch-workloads", false, "Pull images which start being used by Deployments, StatefulSets, DaemonSets and CronJobs in the cluster. Requires permission to list and watch them.")
+	watchCmd.Flags().StringSliceVar(&watchNamespaces, "watch-namespaces", nil, "Namespaces to watch workloads in. Empty means all namespaces.")
+	watchCmd.Flags().StringVar(&watchLabelSelector, "watch-label-selector", "", "Label selector restricting watched workloads, such as team=a.")
+	watchCmd.Flags().DurationVar(&batchDelay, "batch-delay", batchDelay, "Time to wait for more new images before pulling them together in a batch.")
+}
@@ -21,6 +21,11 @@ type settings struct {
 	NeedsPrivileged                      bool
 	CollectMetrics                       bool
 	UseKubeletImageCredentialIntegration string
+	MaxPullingNodes                      int
+	DiscoverWorkloads                    bool
+	WatchWorkloads                       bool
+	ResolveTags                          bool
+	MinFreeDisk                          string
 }
 
 const (
@@ -42,6 +47,11 @@ var (
 	secret                               string
 	collectMetrics                       bool
 	useKubeletImageCredentialIntegration string
+	maxPullingNodes                      int
+	discoverWorkloads                    bool
+	watchWorkloads                       bool
+	resolveTags                          bool
+	minFreeDisk                          string
 )
 
 func init() {
@@ -51,6 +61,11 @@ func init() {
 	flag.StringVar(&secret, "secret", "", "Kubernetes image pull Secret to use when pulling.")
 	flag.BoolVar(&collectMetrics, "collect-metrics", false, "Whether to collect and expose image pull metrics.")
 	flag.StringVar(&useKubeletImageCredentialIntegration, "use-kubelet-image-credential-integration", "", "Enable kubelet image credential provider plugin integration. Accepted values: GKE")
+	flag.IntVar(&maxPullingNodes, "max-pulling-nodes", 0, "Maximum number of nodes pulling images at the same time, cluster-wide. Zero means no limit.")
+	flag.BoolVar(&discoverWorkloads, "discover-workloads", false, "Whether to also prefetch images of workloads running in the cluster. Grants permission to list them cluster-wide.")
+	flag.BoolVar(&watchWorkloads, "watch-workloads", false, "Whether to keep watching workloads running in the cluster and prefetch images they start using, ahead of rollouts. Grants permission to list and watch them cluster-wide.")
+	flag.BoolVar(&resolveTags, "resolve-tags", false, "Whether to resolve image tags to digests once in the metrics aggregator, so that all nodes pull the same digest. Requires --collect-metrics.")
+	flag.StringVar(&minFreeDisk, "min-free-disk", "", "Free space to leave on the image filesystem of nodes, such as 2Gi. Pulls which would leave less are skipped. Mounts the image filesystem read-only to measure it.")
 }
 
 // processVersion processes the version string and returns the appropriate format.
@@ -82,6 +97,10 @@ func main() {
 		os.Exit(1)
 	}
 	name := flag.Arg(0)
+	if resolveTags && !collectMetrics {
+		println("--resolve-tags requires --collect-metrics.")
+		os.Exit(1)
+	}
 	isOcp := k8sFlavor == ocpFlavor
 
 	s := settings{
@@ -94,6 +113,11 @@ func main() {
 		NeedsPrivileged:                      isOcp,
 		CollectMetrics:                       collectMetrics,
 		UseKubeletImageCredentialIntegration: useKubeletImageCredentialIntegration,
+		MaxPullingNodes:                      maxPullingNodes,
+		DiscoverWorkloads:                    discoverWorkloads,
+		WatchWorkloads:                       watchWorkloads,
+		ResolveTags:                          resolveTags,
+		MinFreeDisk:                          minFreeDisk,
 	}
 	tmpl := template.Must(template.New("deployment").Parse(deploymentTemplate))
 	if err := tmpl.Execute(os.Stdout, s); err != nil {
@@ -0,0 +1,217 @@
+// Package coordination limits the number of nodes pulling images at the same time, cluster-wide.
+//
+// It implements a counting semaphore on top of a fixed set of coordination.k8s.io Lease objects ("slots").
+// A node may pull only while it holds one of the slots. Slots are renewed while held, so that a slot
+// held by a node which went away is reclaimed by others once its lease expires.
+package coordination
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/stackrox/image-prefetcher/internal/kube"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordinationclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/utils/ptr"
+)
+
+// Semaphore hands out one of a fixed number of Lease slots.
+type Semaphore struct {
+	client        coordinationclient.LeaseInterface
+	logger        *slog.Logger
+	namePrefix    string
+	holder        string
+	slots         int
+	leaseDuration time.Duration
+	retryInterval time.Duration
+}
+
+// MinLeaseDuration is the shortest lease duration, since leases record their duration in whole seconds.
+const MinLeaseDuration = time.Second
+
+// NewSemaphore creates a semaphore with the given number of slots.
+// Leases are named after namePrefix and held under the holder identity, typically the node name.
+func NewSemaphore(logger *slog.Logger, client coordinationclient.LeaseInterface, namePrefix string, holder string, slots int, leaseDuration time.Duration) (*Semaphore, error) {
+	if leaseDuration < MinLeaseDuration {
+		return nil, fmt.Errorf("lease duration %s is shorter than the minimum of %s", leaseDuration, MinLeaseDuration)
+	}
+	return &Semaphore{
+		client:        client,
+		logger:        logger,
+		namePrefix:    namePrefix,
+		holder:        holder,
+		slots:         slots,
+		leaseDuration: leaseDuration,
+		retryInterval: leaseDuration / 3,
+	}, nil
+}
+
+// Slot is a held semaphore slot. It is renewed in the background until Release is called.
+type Slot struct {
+	semaphore *Semaphore
+	name      string
+	stop      context.CancelFunc
+	done      chan struct{}
+}
+
+// Acquire blocks until one of the slots is obtained, or ctx is done. Only waiting is bounded by ctx:
+// the slot is renewed until it is released, even after ctx is done.
+// API errors other than losing a race for a slot to another holder are returned right away, since waiting would
+// not fix them: for example missing permissions, or a missing namespace.
+func (s *Semaphore) Acquire(ctx context.Context) (*Slot, error) {
+	for {
+		for i := range s.slots {
+			name := s.slotName(i)
+			acquired, err := s.tryAcquire(ctx, name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to acquire pull slot %s: %w", name, err)
+			}
+			if acquired {
+				s.logger.InfoContext(ctx, "acquired pull slot", "lease", name)
+				return s.startRenewing(context.WithoutCancel(ctx), name), nil
+			}
+		}
+		s.logger.InfoContext(ctx, "all pull slots taken, waiting", "slots", s.slots, "retryInterval", s.retryInterval)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for a pull slot: %w", ctx.Err())
+		case <-time.After(s.retryInterval):
+		}
+	}
+}
+
+func (s *Semaphore) slotName(i int) string {
+	return fmt.Sprintf("%s-pull-slot-%d", s.namePrefix, i)
+}
+
+// tryAcquire takes the named lease if it does not exist, is free or has expired.
+// Returns false without error if the lease is held by someone else, or another holder won a race for it.
+func (s *Semaphore) tryAcquire(ctx context.Context, name string) (bool, error) {
+	now := metav1.NewMicroTime(time.Now())
+	lease, err := s.client.Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       s.heldSpec(now),
+		}
+		_, err = s.client.Create(ctx, lease, metav1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+	if !s.isAvailable(lease, now.Time) {
+		return false, nil
+	}
+	lease.Spec = s.heldSpec(now)
+	_, err = s.client.Update(ctx, lease, metav1.UpdateOptions{})
+	if errors.IsConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *Semaphore) isAvailable(lease *coordinationv1.Lease, now time.Time) bool {
+	holder := ptr.Deref(lease.Spec.HolderIdentity, "")
+	if holder == "" || holder == s.holder {
+		return true
+	}
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return now.After(expiry)
+}
+
+func (s *Semaphore) heldSpec(now metav1.MicroTime) coordinationv1.LeaseSpec {
+	return coordinationv1.LeaseSpec{
+		HolderIdentity:       ptr.To(s.holder),
+		LeaseDurationSeconds: ptr.To(int32(s.leaseDuration.Seconds())),
+		AcquireTime:          &now,
+		RenewTime:            &now,
+	}
+}
+
+func (s *Semaphore) startRenewing(ctx context.Context, name string) *Slot {
+	ctx, stop := context.WithCancel(ctx)
+	slot := &Slot{semaphore: s, name: name, stop: stop, done: make(chan struct{})}
+	go func() {
+		defer close(slot.done)
+		ticker := time.NewTicker(s.retryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.renew(ctx, name); err != nil {
+					s.logger.WarnContext(ctx, "failed to renew pull slot", "lease", name, "error", err)
+				}
+			}
+		}
+	}()
+	return slot
+}
+
+func (s *Semaphore) renew(ctx context.Context, name string) error {
+	lease, err := s.client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if holder := ptr.Deref(lease.Spec.HolderIdentity, ""); holder != s.holder {
+		return fmt.Errorf("lease is now held by %q", holder)
+	}
+	lease.Spec.RenewTime = ptr.To(metav1.NewMicroTime(time.Now()))
+	_, err = s.client.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// Release stops renewing the slot and frees it for others, unless someone else already took it over.
+func (sl *Slot) Release(ctx context.Context) error {
+	if sl == nil {
+		return nil
+	}
+	sl.stop()
+	<-sl.done
+	s := sl.semaphore
+	lease, err := s.client.Get(ctx, sl.name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get lease %s: %w", sl.name, err)
+	}
+	if ptr.Deref(lease.Spec.HolderIdentity, "") != s.holder {
+		return nil
+	}
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.AcquireTime = nil
+	lease.Spec.RenewTime = nil
+	if _, err := s.client.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to release lease %s: %w", sl.name, err)
+	}
+	s.logger.InfoContext(ctx, "released pull slot", "lease", sl.name)
+	return nil
+}
+
+// NewSemaphoreFromEnvironment creates a semaphore for the prefetcher instance and node given in the
+// INSTANCE_NAME, NODE_NAME and POD_NAMESPACE environment variables, using in-cluster configuration.
+func NewSemaphoreFromEnvironment(logger *slog.Logger, slots int, leaseDuration time.Duration) (*Semaphore, error) {
+	instanceName := os.Getenv("INSTANCE_NAME")
+	nodeName := os.Getenv("NODE_NAME")
+	namespace := os.Getenv("POD_NAMESPACE")
+	if instanceName == "" || nodeName == "" || namespace == "" {
+		return nil, fmt.Errorf("INSTANCE_NAME, NODE_NAME and POD_NAMESPACE environment variables must all be set, got %q, %q and %q", instanceName, nodeName, namespace)
+	}
+	clientset, err := kube.NewClientset()
+	if err != nil {
+		return nil, err
+	}
+	return NewSemaphore(logger, clientset.CoordinationV1().Leases(namespace), instanceName, nodeName, slots, leaseDuration)
+}
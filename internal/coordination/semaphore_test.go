@@ -0,0 +1,146 @@
+package coordination
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+)
+
+func holderOf(t *testing.T, client *fake.Clientset, name string) string {
+	lease, err := client.CoordinationV1().Leases("ns").Get(t.Context(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+	return ptr.Deref(lease.Spec.HolderIdentity, "")
+}
+
+func TestSemaphore(t *testing.T) {
+	client := fake.NewClientset()
+	leases := client.CoordinationV1().Leases("ns")
+	newSemaphore := func(holder string) *Semaphore {
+		s, err := NewSemaphore(slogt.New(t), leases, "my-images", holder, 2, 3*time.Second)
+		require.NoError(t, err)
+		return s
+	}
+
+	slotA, err := newSemaphore("node-a").Acquire(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "my-images-pull-slot-0", slotA.name)
+	slotB, err := newSemaphore("node-b").Acquire(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "my-images-pull-slot-1", slotB.name)
+	assert.Equal(t, "node-a", holderOf(t, client, slotA.name))
+	assert.Equal(t, "node-b", holderOf(t, client, slotB.name))
+
+	// All slots are taken.
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	_, err = newSemaphore("node-c").Acquire(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.NoError(t, slotA.Release(t.Context()))
+	assert.Equal(t, "", holderOf(t, client, slotA.name))
+
+	slotC, err := newSemaphore("node-c").Acquire(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "my-images-pull-slot-0", slotC.name)
+	require.NoError(t, slotC.Release(t.Context()))
+	require.NoError(t, slotB.Release(t.Context()))
+}
+
+func TestSemaphoreTakesOverExpiredLease(t *testing.T) {
+	stale := metav1.NewMicroTime(time.Now().Add(-time.Hour))
+	client := fake.NewClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-images-pull-slot-0", Namespace: "ns"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       ptr.To("gone-node"),
+			LeaseDurationSeconds: ptr.To(int32(30)),
+			RenewTime:            &stale,
+		},
+	})
+	s, err := NewSemaphore(slogt.New(t), client.CoordinationV1().Leases("ns"), "my-images", "node-a", 1, 30*time.Second)
+	require.NoError(t, err)
+	slot, err := s.Acquire(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "node-a", holderOf(t, client, slot.name))
+	require.NoError(t, slot.Release(t.Context()))
+}
+
+func TestSemaphoreFailsOnAPIErrors(t *testing.T) {
+	client := fake.NewClientset()
+	client.PrependReactor("get", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "coordination.k8s.io", Resource: "leases"}, "my-images-pull-slot-0", errors.New("RBAC"))
+	})
+	s, err := NewSemaphore(slogt.New(t), client.CoordinationV1().Leases("ns"), "my-images", "node-a", 2, 30*time.Second)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	_, err = s.Acquire(ctx)
+
+	assert.True(t, apierrors.IsForbidden(err), "error should be returned right away: %v", err)
+	assert.NoError(t, ctx.Err())
+}
+
+func TestSemaphoreRetriesLostRace(t *testing.T) {
+	client := fake.NewClientset(&coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: "my-images-pull-slot-0", Namespace: "ns"}})
+	conflicts := 1
+	client.PrependReactor("update", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			return false, nil, nil
+		}
+		conflicts--
+		return true, nil, apierrors.NewConflict(schema.GroupResource{Group: "coordination.k8s.io", Resource: "leases"}, "my-images-pull-slot-0", errors.New("modified"))
+	})
+	s, err := NewSemaphore(slogt.New(t), client.CoordinationV1().Leases("ns"), "my-images", "node-a", 1, time.Second)
+	require.NoError(t, err)
+
+	slot, err := s.Acquire(t.Context())
+
+	require.NoError(t, err)
+	assert.Equal(t, "node-a", holderOf(t, client, slot.name))
+	require.NoError(t, slot.Release(t.Context()))
+}
+
+func TestSemaphoreRenewsAfterWaitingEnded(t *testing.T) {
+	client := fake.NewClientset()
+	leases := client.CoordinationV1().Leases("ns")
+	s, err := NewSemaphore(slogt.New(t), leases, "my-images", "node-a", 1, time.Second)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(t.Context())
+
+	slot, err := s.Acquire(ctx)
+	require.NoError(t, err)
+	cancel()
+	acquired, err := leases.Get(t.Context(), slot.name, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		lease, err := leases.Get(t.Context(), slot.name, metav1.GetOptions{})
+		return err == nil && lease.Spec.RenewTime.After(acquired.Spec.RenewTime.Time)
+	}, 5*time.Second, 50*time.Millisecond)
+	require.NoError(t, slot.Release(t.Context()))
+}
+
+func TestNewSemaphoreRejectsShortLeases(t *testing.T) {
+	for _, duration := range []time.Duration{0, 500 * time.Millisecond} {
+		_, err := NewSemaphore(slogt.New(t), fake.NewClientset().CoordinationV1().Leases("ns"), "my-images", "node-a", 1, duration)
+		assert.ErrorContains(t, err, "shorter than the minimum of 1s")
+	}
+}
+
+func TestNilSlotRelease(t *testing.T) {
+	var slot *Slot
+	assert.NoError(t, slot.Release(t.Context()))
+}
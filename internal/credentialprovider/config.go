@@ -20,6 +20,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"os"
 	"strings"
 )
 
@@ -31,6 +32,19 @@ type DockerConfigJSON struct {
 	HTTPHeaders map[string]string `json:"HttpHeaders,omitempty"`
 }
 
+// ReadDockerConfigJSONFile reads the credentials from a docker config json file, such as a mounted pull secret.
+func ReadDockerConfigJSONFile(path string) (DockerConfig, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed read %q: %w", path, err)
+	}
+	dockerConfigJSON := DockerConfigJSON{}
+	if err := json.Unmarshal(contents, &dockerConfigJSON); err != nil {
+		return nil, fmt.Errorf("unmarshalling docker config failed: %w", err)
+	}
+	return dockerConfigJSON.Auths, nil
+}
+
 // DockerConfig represents the config file used by the docker CLI.
 // This config that represents the credentials that should be used
 // when pulling images from specific image repositories.
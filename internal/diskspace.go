@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	metricsProto "github.com/stackrox/image-prefetcher/internal/metrics/gen"
+	"github.com/stackrox/image-prefetcher/internal/pullerrors"
+
+	"golang.org/x/sys/unix"
+	criV1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// DiskSpaceConfig configures checks of free space on the image filesystem of the node.
+type DiskSpaceConfig struct {
+	// MinFreeBytes is the free space to leave on the image filesystem. Pulls which would leave less are skipped.
+	// Zero disables the checks.
+	MinFreeBytes uint64
+	// ImageFsPath is where the image filesystem is visible to the prefetcher, for measuring free space.
+	// Empty means the mount point reported by the runtime.
+	ImageFsPath string
+}
+
+// Stages at which usage of the image filesystem is sampled.
+const (
+	fsStageBefore = "before"
+	fsStageAfter  = "after"
+)
+
+const fsUsageReportTimeout = 10 * time.Second
+
+// imageFsUsage is the usage of the image filesystem of the node.
+type imageFsUsage struct {
+	mountpoint string
+	usedBytes  uint64
+	inodesUsed uint64
+	// path is where free space was measured, or empty if it could not be.
+	path           string
+	availableBytes uint64
+}
+
+// statfsAvailableBytes returns the space available to unprivileged users on the filesystem containing path.
+func statfsAvailableBytes(path string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// imageFsUsage asks the runtime about usage of its image filesystem, and measures free space on it if possible.
+// The runtime only reports used space, so free space can only be measured if the filesystem is visible to the prefetcher.
+func (f *prefetcher) imageFsUsage(ctx context.Context) (*imageFsUsage, error) {
+	infoCtx, cancel := context.WithTimeout(ctx, f.config.Timing.ImageListTimeout)
+	defer cancel()
+	info, err := f.criClient.ImageFsInfo(infoCtx, &criV1.ImageFsInfoRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to call ImageFsInfo: %w", err)
+	}
+	filesystems := info.GetImageFilesystems()
+	if len(filesystems) == 0 {
+		return nil, errors.New("runtime reported no image filesystem")
+	}
+	usage := &imageFsUsage{mountpoint: filesystems[0].GetFsId().GetMountpoint()}
+	for _, fs := range filesystems {
+		usage.usedBytes += fs.GetUsedBytes().GetValue()
+		usage.inodesUsed += fs.GetInodesUsed().GetValue()
+	}
+	path := cmp.Or(f.config.DiskSpace.ImageFsPath, usage.mountpoint)
+	if path == "" || f.freeSpace == nil {
+		return usage, nil
+	}
+	available, err := f.freeSpace(path)
+	if err != nil {
+		f.logger.WarnContext(ctx, "failed to measure free space on image filesystem", "path", path, "error", err)
+		return usage, nil
+	}
+	usage.path, usage.availableBytes = path, available
+	return usage, nil
+}
+
+// sampleImageFsUsage logs usage of the image filesystem at the given stage and reports it to the metrics endpoint,
+// along with the expected size of images about to be pulled, if any. Returns nil if usage could not be obtained.
+func (f *prefetcher) sampleImageFsUsage(ctx context.Context, stage string, expectedPullBytes uint64) *imageFsUsage {
+	usage, err := f.imageFsUsage(ctx)
+	if err != nil {
+		f.logger.WarnContext(ctx, "failed to obtain image filesystem usage", "stage", stage, "error", err)
+		return nil
+	}
+	f.logger.InfoContext(ctx, "image filesystem usage", "stage", stage, "mountpoint", usage.mountpoint,
+		"usedBytes", usage.usedBytes, "inodesUsed", usage.inodesUsed, "availableBytes", usage.availableBytes,
+		"expectedPullBytes", expectedPullBytes)
+	if f.metricsClient == nil {
+		return usage
+	}
+	node, err := os.Hostname()
+	if err != nil {
+		node = "unknown"
+	}
+	reportCtx, cancel := context.WithTimeout(ctx, fsUsageReportTimeout)
+	defer cancel()
+	_, err = f.metricsClient.ReportFilesystemUsage(reportCtx, &metricsProto.FilesystemUsage{
+		Node:              node,
+		Stage:             stage,
+		SampledAt:         time.Now().Unix(),
+		Mountpoint:        usage.mountpoint,
+		UsedBytes:         usage.usedBytes,
+		InodesUsed:        usage.inodesUsed,
+		AvailableBytes:    usage.availableBytes,
+		ExpectedPullBytes: expectedPullBytes,
+	})
+	if err != nil {
+		f.logger.WarnContext(ctx, "failed to report image filesystem usage", "stage", stage, "error", err)
+	}
+	return usage
+}
+
+// preflightDiskSpace samples usage of the image filesystem before pulling. If a minimum of free space is configured
+// and free space can be measured, jobs which would not fit above the minimum, as far as their sizes are known from
+// earlier pulls, are failed without pulling, and the puller checks free space again before each pull.
+// Jobs are considered in pull order, so that lower-priority images are skipped first. Returns the jobs to pull.
+func (f *prefetcher) preflightDiskSpace(ctx context.Context, plan *jobPlan, p *puller) []*pullJob {
+	var expected uint64
+	counted := make(map[string]bool)
+	for _, job := range plan.jobs {
+		// Images pulled for several runtime handlers share their content.
+		if !counted[job.image] {
+			expected += plan.sizes[job.image]
+			counted[job.image] = true
+		}
+	}
+	usage := f.sampleImageFsUsage(ctx, fsStageBefore, expected)
+	minFree := f.config.DiskSpace.MinFreeBytes
+	if minFree == 0 {
+		return plan.jobs
+	}
+	if usage == nil || usage.path == "" {
+		f.logger.WarnContext(ctx, "free space on image filesystem unknown, not checking it before pulls")
+		return plan.jobs
+	}
+	p.diskSpace = &diskSpaceGuard{path: usage.path, minFreeBytes: minFree, freeSpace: f.freeSpace}
+	budget := usage.availableBytes - min(usage.availableBytes, minFree)
+	budgeted := make(map[string]bool)
+	var pulls []*pullJob
+	for _, job := range plan.jobs {
+		size := plan.sizes[job.image]
+		if budgeted[job.image] {
+			size = 0
+		}
+		if size > budget {
+			p.failWithoutPulling(ctx, job, 0, fmt.Errorf("%w: image of %d bytes would leave less than %d bytes free on the image filesystem", pullerrors.ErrNoSpace, size, minFree))
+			continue
+		}
+		budget -= size
+		budgeted[job.image] = true
+		pulls = append(pulls, job)
+	}
+	if skipped := len(plan.jobs) - len(pulls); skipped > 0 {
+		f.logger.WarnContext(ctx, "skipping pulls which would not fit on the image filesystem", "skipped", skipped,
+			"availableBytes", usage.availableBytes, "minFreeBytes", minFree, "expectedPullBytes", expected)
+	}
+	return pulls
+}
+
+// diskSpaceGuard stops pulls once free space on the image filesystem drops below a minimum.
+type diskSpaceGuard struct {
+	path         string
+	minFreeBytes uint64
+	freeSpace    func(path string) (uint64, error)
+}
+
+// check returns an error wrapping pullerrors.ErrNoSpace if free space is below the minimum.
+// A nil guard, or failure to measure free space, permits pulls.
+func (g *diskSpaceGuard) check(logger *slog.Logger) error {
+	if g == nil {
+		return nil
+	}
+	available, err := g.freeSpace(g.path)
+	if err != nil {
+		logger.Warn("failed to measure free space on image filesystem, pulling anyway", "path", g.path, "error", err)
+		return nil
+	}
+	if available < g.minFreeBytes {
+		return fmt.Errorf("%w: %d bytes free on the image filesystem, less than the minimum of %d", pullerrors.ErrNoSpace, available, g.minFreeBytes)
+	}
+	return nil
+}
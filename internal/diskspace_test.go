@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stackrox/image-prefetcher/internal/nodelabels"
+	"github.com/stackrox/image-prefetcher/internal/pullerrors"
+
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	criV1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+func TestPreflightDiskSpace(t *testing.T) {
+	jobs := func() []*pullJob {
+		return []*pullJob{
+			{pullTarget: pullTarget{image: "high"}, priority: 10},
+			{pullTarget: pullTarget{image: "large"}},
+			{pullTarget: pullTarget{image: "unknown"}},
+			{pullTarget: pullTarget{image: "small"}},
+			{pullTarget: pullTarget{image: "small", runtimeHandler: "kata"}},
+		}
+	}
+	sizes := map[string]uint64{"high": 40, "large": 50, "small": 10}
+	imageFs := &criV1.FilesystemUsage{
+		FsId:       &criV1.FilesystemIdentifier{Mountpoint: "/var/lib/containerd"},
+		UsedBytes:  &criV1.UInt64Value{Value: 900},
+		InodesUsed: &criV1.UInt64Value{Value: 5},
+	}
+	tests := map[string]struct {
+		config            DiskSpaceConfig
+		imageFs           *criV1.FilesystemUsage
+		freeSpaceErr      error
+		expectedPulls     []string
+		expectedSkipped   []string
+		expectedPath      string
+		expectedAvailable uint64
+		expectedReports   int
+	}{
+		"disabled": {
+			imageFs:           imageFs,
+			expectedPulls:     []string{"high", "large", "unknown", "small", "small"},
+			expectedAvailable: 100,
+			expectedReports:   1,
+		},
+		"lower priority images skipped": {
+			config:            DiskSpaceConfig{MinFreeBytes: 30},
+			imageFs:           imageFs,
+			expectedPulls:     []string{"high", "unknown", "small", "small"},
+			expectedSkipped:   []string{"large"},
+			expectedPath:      "/var/lib/containerd",
+			expectedAvailable: 100,
+			expectedReports:   1,
+		},
+		"configured path": {
+			config:            DiskSpaceConfig{MinFreeBytes: 95, ImageFsPath: "/host/containerd"},
+			imageFs:           imageFs,
+			expectedPulls:     []string{"unknown"},
+			expectedSkipped:   []string{"high", "large", "small", "small"},
+			expectedPath:      "/host/containerd",
+			expectedAvailable: 100,
+			expectedReports:   1,
+		},
+		"free space unknown": {
+			config:          DiskSpaceConfig{MinFreeBytes: 30},
+			imageFs:         imageFs,
+			freeSpaceErr:    errors.New("no such file or directory"),
+			expectedPulls:   []string{"high", "large", "unknown", "small", "small"},
+			expectedReports: 1,
+		},
+		"image filesystem info unsupported": {
+			config:        DiskSpaceConfig{MinFreeBytes: 30},
+			expectedPulls: []string{"high", "large", "unknown", "small", "small"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			metricsClient := &fakeMetricsClient{}
+			var measured []string
+			f := &prefetcher{
+				logger:        slogt.New(t),
+				config:        Config{Timing: testTiming, DiskSpace: test.config},
+				criClient:     &fakeImageService{imageFs: test.imageFs},
+				metricsClient: metricsClient,
+				freeSpace: func(path string) (uint64, error) {
+					measured = append(measured, path)
+					return 100, test.freeSpaceErr
+				},
+			}
+			var results, failures sync.Map
+			p := &puller{results: &results, failures: &failures}
+			plan := &jobPlan{jobs: jobs(), sizes: sizes}
+			for _, job := range plan.jobs {
+				job.logger = f.logger
+			}
+
+			pulls := f.preflightDiskSpace(t.Context(), plan, p)
+
+			var pulled []string
+			for _, job := range pulls {
+				pulled = append(pulled, job.image)
+			}
+			assert.Equal(t, test.expectedPulls, pulled)
+			var skipped []string
+			for _, job := range plan.jobs {
+				if class, ok := failures.Load(job.pullTarget); ok {
+					skipped = append(skipped, job.image)
+					assert.Equal(t, pullerrors.ClassNoSpace, class)
+					outcome, _ := results.Load(job.pullTarget)
+					assert.Equal(t, nodelabels.OutcomeFailed, outcome)
+				}
+			}
+			assert.Equal(t, test.expectedSkipped, skipped)
+			if test.expectedPath != "" {
+				require.NotNil(t, p.diskSpace)
+				assert.Equal(t, test.expectedPath, p.diskSpace.path)
+				assert.Equal(t, test.config.MinFreeBytes, p.diskSpace.minFreeBytes)
+			} else {
+				assert.Nil(t, p.diskSpace)
+			}
+			require.Len(t, metricsClient.filesystems, test.expectedReports)
+			if test.expectedReports > 0 {
+				report := metricsClient.filesystems[0]
+				assert.Equal(t, fsStageBefore, report.Stage)
+				assert.Equal(t, "/var/lib/containerd", report.Mountpoint)
+				assert.Equal(t, uint64(900), report.UsedBytes)
+				assert.Equal(t, uint64(5), report.InodesUsed)
+				assert.Equal(t, test.expectedAvailable, report.AvailableBytes)
+				assert.Equal(t, uint64(100), report.ExpectedPullBytes)
+				assert.NotEmpty(t, measured)
+			}
+		})
+	}
+}
+
+func TestDiskSpaceGuard(t *testing.T) {
+	available := uint64(100)
+	guard := &diskSpaceGuard{path: "/images", minFreeBytes: 50, freeSpace: func(string) (uint64, error) { return available, nil }}
+	client := &fakeImageService{}
+	var results, failures sync.Map
+	p := &puller{client: client, timing: testTiming, results: &results, failures: &failures, diskSpace: guard}
+	logger := slogt.New(t)
+	enough := &pullJob{pullTarget: pullTarget{image: "enough"}, logger: logger, credentials: []credential{{source: credentialSourceAnonymous}}}
+	tooLate := &pullJob{pullTarget: pullTarget{image: "too-late"}, logger: logger, credentials: []credential{{source: credentialSourceAnonymous}}}
+
+	p.pullImage(t.Context(), enough, 0)
+	available = 49
+	p.pullImage(t.Context(), tooLate, 0)
+
+	require.Len(t, client.pulls, 1)
+	assert.Equal(t, "enough", client.pulls[0].GetImage().GetImage())
+	outcome, _ := results.Load(tooLate.pullTarget)
+	assert.Equal(t, nodelabels.OutcomeFailed, outcome)
+	class, _ := failures.Load(tooLate.pullTarget)
+	assert.Equal(t, pullerrors.ClassNoSpace, class)
+
+	var nilGuard *diskSpaceGuard
+	assert.NoError(t, nilGuard.check(logger))
+}
@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/stackrox/image-prefetcher/internal/pullerrors"
+)
+
+// FailOn determines which failed images can make the fetch fail.
+type FailOn string
+
+const (
+	// FailOnAny counts failures of all images, except those marked as not required in the image list.
+	FailOnAny FailOn = "any"
+	// FailOnRequired counts only failures of images marked as required in the image list.
+	FailOnRequired FailOn = "required"
+	// FailOnNever never fails the fetch because of failed pulls.
+	FailOnNever FailOn = "never"
+)
+
+// ParseFailOn validates the given failure policy name.
+func ParseFailOn(s string) (FailOn, error) {
+	switch f := FailOn(s); f {
+	case FailOnAny, FailOnRequired, FailOnNever:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown failure policy %q, expected one of %s, %s or %s", s, FailOnAny, FailOnRequired, FailOnNever)
+}
+
+// FailurePolicy determines when failed pulls make the fetch fail.
+// Zero value never fails, as was the historical behaviour.
+type FailurePolicy struct {
+	FailOn FailOn
+	// MaxFailures is the number of counted failures which is still tolerated.
+	MaxFailures int
+}
+
+// ParseFailurePolicy validates the given failure policy name and number of tolerated failures.
+func ParseFailurePolicy(failOn string, maxFailures int) (FailurePolicy, error) {
+	f, err := ParseFailOn(failOn)
+	if err != nil {
+		return FailurePolicy{}, err
+	}
+	if maxFailures < 0 {
+		return FailurePolicy{}, fmt.Errorf("invalid number of tolerated failures %d, must not be negative", maxFailures)
+	}
+	return FailurePolicy{FailOn: f, MaxFailures: maxFailures}, nil
+}
+
+// counts returns whether a failure of an image with the given requiredness counts against the policy.
+func (p FailurePolicy) counts(required *bool) bool {
+	switch p.FailOn {
+	case FailOnAny:
+		return required == nil || *required
+	case FailOnRequired:
+		return required != nil && *required
+	}
+	return false
+}
+
+// check returns a *PullFailedError if the counted failures among jobs exceed the policy, nil otherwise.
+func (p FailurePolicy) check(jobs []*pullJob, failures *sync.Map) error {
+	counted := make(map[pullTarget]pullerrors.Class)
+	for _, job := range jobs {
+		class, failed := failures.Load(job.pullTarget)
+		if failed && p.counts(job.required) {
+			counted[job.pullTarget] = class.(pullerrors.Class)
+		}
+	}
+	if len(counted) <= p.MaxFailures {
+		return nil
+	}
+	return &PullFailedError{failures: counted, maxFailures: p.MaxFailures}
+}
+
+// Exit codes of PullFailedError. Codes 0 and 1 are reserved for success and other errors.
+const (
+	ExitCodeMixedFailures    = 2
+	ExitCodeNotFound         = 3
+	ExitCodeInvalidReference = 4
+	ExitCodeUnauthorized     = 5
+	ExitCodeDigestMismatch   = 6
+	ExitCodeTransient        = 7
+	ExitCodeUnknown          = 8
+	ExitCodeNoSpace          = 9
+)
+
+// PullFailedError is returned by Run when failed pulls exceed the failure policy.
+type PullFailedError struct {
+	failures    map[pullTarget]pullerrors.Class
+	maxFailures int
+}
+
+func (e *PullFailedError) Error() string {
+	var descriptions []string
+	for target, class := range e.failures {
+		description := target.image
+		if target.runtimeHandler != "" {
+			description += " (runtime handler " + target.runtimeHandler + ")"
+		}
+		descriptions = append(descriptions, fmt.Sprintf("%s: %s", description, class))
+	}
+	slices.Sort(descriptions)
+	return fmt.Sprintf("%d image pulls failed, at most %d tolerated: %s", len(e.failures), e.maxFailures, strings.Join(descriptions, ", "))
+}
+
+// ExitCode returns the process exit code reflecting the class of failures,
+// or ExitCodeMixedFailures if failures fall into different classes.
+func (e *PullFailedError) ExitCode() int {
+	code := 0
+	for _, class := range e.failures {
+		classCode := exitCode(class)
+		if code != 0 && classCode != code {
+			return ExitCodeMixedFailures
+		}
+		code = classCode
+	}
+	return code
+}
+
+func exitCode(class pullerrors.Class) int {
+	switch class {
+	case pullerrors.ClassNotFound:
+		return ExitCodeNotFound
+	case pullerrors.ClassInvalidReference:
+		return ExitCodeInvalidReference
+	case pullerrors.ClassUnauthorized:
+		return ExitCodeUnauthorized
+	case pullerrors.ClassDigestMismatch:
+		return ExitCodeDigestMismatch
+	case pullerrors.ClassNoSpace:
+		return ExitCodeNoSpace
+	case pullerrors.ClassDeadline, pullerrors.ClassUnavailable, pullerrors.ClassRateLimited, pullerrors.ClassServerError:
+		return ExitCodeTransient
+	}
+	return ExitCodeUnknown
+}
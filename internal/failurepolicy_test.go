@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stackrox/image-prefetcher/internal/pullerrors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailurePolicyCheck(t *testing.T) {
+	yes, no := true, false
+	jobs := []*pullJob{
+		{pullTarget: pullTarget{image: "plain"}},
+		{pullTarget: pullTarget{image: "required"}, required: &yes},
+		{pullTarget: pullTarget{image: "optional"}, required: &no},
+		{pullTarget: pullTarget{image: "ok"}},
+	}
+	tests := map[string]struct {
+		policy           FailurePolicy
+		failures         map[string]pullerrors.Class
+		expectedExitCode int // zero means no error expected
+	}{
+		"no failures": {
+			policy: FailurePolicy{FailOn: FailOnAny},
+		},
+		"zero value never fails": {
+			failures: map[string]pullerrors.Class{"plain": pullerrors.ClassNotFound, "required": pullerrors.ClassNotFound},
+		},
+		"never": {
+			policy:   FailurePolicy{FailOn: FailOnNever},
+			failures: map[string]pullerrors.Class{"required": pullerrors.ClassNotFound},
+		},
+		"any counts unmarked images": {
+			policy:           FailurePolicy{FailOn: FailOnAny},
+			failures:         map[string]pullerrors.Class{"plain": pullerrors.ClassNotFound},
+			expectedExitCode: ExitCodeNotFound,
+		},
+		"any skips optional images": {
+			policy:   FailurePolicy{FailOn: FailOnAny},
+			failures: map[string]pullerrors.Class{"optional": pullerrors.ClassNotFound},
+		},
+		"required skips unmarked images": {
+			policy:   FailurePolicy{FailOn: FailOnRequired},
+			failures: map[string]pullerrors.Class{"plain": pullerrors.ClassNotFound, "optional": pullerrors.ClassNotFound},
+		},
+		"required counts required images": {
+			policy:           FailurePolicy{FailOn: FailOnRequired},
+			failures:         map[string]pullerrors.Class{"required": pullerrors.ClassUnauthorized},
+			expectedExitCode: ExitCodeUnauthorized,
+		},
+		"failures within max": {
+			policy:   FailurePolicy{FailOn: FailOnAny, MaxFailures: 2},
+			failures: map[string]pullerrors.Class{"plain": pullerrors.ClassNotFound, "required": pullerrors.ClassUnauthorized},
+		},
+		"failures above max": {
+			policy:           FailurePolicy{FailOn: FailOnAny, MaxFailures: 1},
+			failures:         map[string]pullerrors.Class{"plain": pullerrors.ClassDeadline, "required": pullerrors.ClassUnavailable},
+			expectedExitCode: ExitCodeTransient,
+		},
+		"mixed classes": {
+			policy:           FailurePolicy{FailOn: FailOnAny},
+			failures:         map[string]pullerrors.Class{"plain": pullerrors.ClassNotFound, "required": pullerrors.ClassDigestMismatch},
+			expectedExitCode: ExitCodeMixedFailures,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var failures sync.Map
+			for image, class := range test.failures {
+				failures.Store(pullTarget{image: image}, class)
+			}
+			err := test.policy.check(jobs, &failures)
+			if test.expectedExitCode == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var pullFailed *PullFailedError
+			require.ErrorAs(t, err, &pullFailed)
+			assert.Equal(t, test.expectedExitCode, pullFailed.ExitCode())
+		})
+	}
+}
+
+func TestParseFailurePolicy(t *testing.T) {
+	policy, err := ParseFailurePolicy("any", 2)
+	require.NoError(t, err)
+	assert.Equal(t, FailurePolicy{FailOn: FailOnAny, MaxFailures: 2}, policy)
+	_, err = ParseFailurePolicy("sometimes", 0)
+	assert.ErrorContains(t, err, "unknown failure policy")
+	_, err = ParseFailurePolicy("any", -1)
+	assert.ErrorContains(t, err, "must not be negative")
+}
+
+func TestPullFailedErrorMessage(t *testing.T) {
+	err := &PullFailedError{
+		failures: map[pullTarget]pullerrors.Class{
+			{image: "b"}:                         pullerrors.ClassNotFound,
+			{image: "a", runtimeHandler: "kata"}: pullerrors.ClassUnauthorized,
+		},
+	}
+	assert.Equal(t, "2 image pulls failed, at most 0 tolerated: a (runtime handler kata): unauthorized, b: not-found", err.Error())
+}
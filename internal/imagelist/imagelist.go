@@ -0,0 +1,327 @@
+// Package imagelist parses the list of images to prefetch.
+//
+// The list is either a text file or a structured YAML or JSON file, told apart by the first line which is
+// not blank or a comment.
+//
+// The text format has one image per line. Lines starting with # and blank ones are ignored.
+// An image name may be followed by whitespace-separated key=value attributes which override global settings
+// for that image, for example:
+//
+//	quay.io/strimzi/kafka:latest-kafka-3.7.0 pullPolicy=IfNotPresent expectedDigest=sha256:0123...
+//	quay.io/example/sandboxed:v1 runtimeHandlers=kata,runc sandboxNamespace=my-app
+//	quay.io/example/critical:v2 priority=10 required=true
+//	nvcr.io/nvidia/cuda:12.4.1-runtime-ubuntu22.04 nodeSelector=nvidia.com/gpu.present=true
+//
+// In both formats, image names are validated and normalized (see ParseReference), and repeated entries of an image
+// are dropped.
+//
+// The structured format can additionally hold settings which do not fit the text format, for example:
+//
+//	images:
+//	- name: quay.io/example/critical:v2
+//	  priority: 10
+//	  required: true
+//	  pullPolicy: IfNotPresent
+//	  expectedDigest: sha256:0123...
+//	  runtimeHandlers: [kata]
+//	  sandboxNamespace: my-app
+//	  sandboxLabels: {team: a}
+//	  sandboxAnnotations: {example.com/snapshotter: stargz}
+//	  platform: linux/arm64
+//	  nodeSelector:
+//	    matchExpressions:
+//	    - {key: nvidia.com/gpu.present, operator: Exists}
+//	  timeouts:
+//	    initialPullAttempt: 1m
+//	    maxPullAttempt: 10m
+package imagelist
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// PullPolicy determines whether an image is pulled if it is already present in the container runtime.
+type PullPolicy string
+
+const (
+	// PullAlways pulls the image unconditionally.
+	PullAlways PullPolicy = "Always"
+	// PullIfNotPresent pulls the image only if the runtime does not have it yet.
+	PullIfNotPresent PullPolicy = "IfNotPresent"
+)
+
+// ParsePullPolicy validates the given pull policy name.
+func ParsePullPolicy(s string) (PullPolicy, error) {
+	switch p := PullPolicy(s); p {
+	case PullAlways, PullIfNotPresent:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown pull policy %q, expected %s or %s", s, PullAlways, PullIfNotPresent)
+}
+
+// Image is a single entry of the image list.
+type Image struct {
+	Name string
+	// PullPolicy overrides the global pull policy, unless empty.
+	PullPolicy PullPolicy
+	// ExpectedDigest is the manifest digest the pulled image must have, if not empty.
+	// It is also taken from the name, if the name is a digest reference.
+	ExpectedDigest string
+	// RuntimeHandlers overrides the global list of runtime handlers to pull the image for, unless empty.
+	RuntimeHandlers []string
+	// SandboxNamespace overrides the namespace of the synthetic pod sandbox config passed with pull requests, unless empty.
+	SandboxNamespace string
+	// SandboxLabels and SandboxAnnotations are merged over the global labels and annotations of the synthetic pod
+	// sandbox config. Only the structured format can hold them.
+	SandboxLabels      map[string]string
+	SandboxAnnotations map[string]string
+	// Priority determines pull order for the priority-aware pull orders. Higher priority images are pulled first.
+	Priority int
+	// Required marks the image as required (true) or optional (false) for the failure policy of fetch, unless nil.
+	Required *bool
+	// Platform restricts the image to nodes of the given os/arch, unless empty.
+	Platform string
+	// NodeSelector restricts the image to nodes with matching labels, unless nil.
+	NodeSelector labels.Selector
+	// InitialPullAttemptTimeout and MaxPullAttemptTimeout override the global pull attempt timeouts, unless zero.
+	InitialPullAttemptTimeout time.Duration
+	MaxPullAttemptTimeout     time.Duration
+}
+
+// digestRegexp matches the digest formats used by OCI registries.
+var digestRegexp = regexp.MustCompile(`^(sha256:[a-f0-9]{64}|sha512:[a-f0-9]{128})$`)
+
+// ParseDigest validates the given manifest digest.
+func ParseDigest(s string) (string, error) {
+	if !digestRegexp.MatchString(s) {
+		return "", fmt.Errorf("invalid digest %q, expected sha256:<64 hex digits> or sha512:<128 hex digits>", s)
+	}
+	return s, nil
+}
+
+// ParseRuntimeHandlers parses a comma-separated list of CRI runtime handler names.
+func ParseRuntimeHandlers(s string) ([]string, error) {
+	handlers := strings.Split(s, ",")
+	for _, handler := range handlers {
+		if handler == "" {
+			return nil, fmt.Errorf("empty runtime handler name in %q", s)
+		}
+	}
+	return handlers, nil
+}
+
+// ParsePlatform validates a platform of the form os/arch, such as linux/arm64.
+func ParsePlatform(s string) (string, error) {
+	goos, arch, found := strings.Cut(s, "/")
+	if !found || goos == "" || arch == "" || strings.Contains(arch, "/") {
+		return "", fmt.Errorf("invalid platform %q, expected os/arch such as linux/amd64", s)
+	}
+	return s, nil
+}
+
+// ParseNodeSelector parses a label selector in the syntax of kubectl, such as nvidia.com/gpu.present=true.
+func ParseNodeSelector(s string) (labels.Selector, error) {
+	selector, err := labels.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node selector %q: %w", s, err)
+	}
+	return selector, nil
+}
+
+// ParseNamespace validates a Kubernetes namespace name.
+func ParseNamespace(s string) (string, error) {
+	if errs := validation.IsDNS1123Label(s); len(errs) > 0 {
+		return "", fmt.Errorf("invalid namespace %q: %s", s, strings.Join(errs, ", "))
+	}
+	return s, nil
+}
+
+// nameDigest returns the digest part of a digest reference, or an empty string if the name is not one.
+func nameDigest(name string) string {
+	if _, digest, found := strings.Cut(name, "@"); found {
+		return digest
+	}
+	return ""
+}
+
+// LoadFile parses the image list in the given file. An empty file name results in an empty list.
+func LoadFile(fileName string) ([]Image, error) {
+	if fileName == "" {
+		return nil, nil
+	}
+	bytes, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	images, err := Parse(bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return images, nil
+}
+
+// Parse parses the image list, in either the text or the structured format.
+func Parse(bytes []byte) ([]Image, error) {
+	if isStructured(bytes) {
+		return parseStructured(bytes)
+	}
+	var images deduplicator
+	for i, line := range strings.Split(string(bytes), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		position := fmt.Sprintf("line %d", i+1)
+		image, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", position, err)
+		}
+		if err := images.add(image, position); err != nil {
+			return nil, err
+		}
+	}
+	return images.images, nil
+}
+
+// deduplicator collects images, dropping repeated entries of the same image.
+type deduplicator struct {
+	images    []Image
+	positions []string       // where each image was listed first
+	indexes   map[string]int // of images, by name
+}
+
+// add adds the image listed at the given position, unless an identical entry of the same image was added before.
+// Entries of the same image with different attributes are rejected, since it is unclear which should apply.
+func (d *deduplicator) add(image Image, position string) error {
+	if d.indexes == nil {
+		d.indexes = map[string]int{}
+	}
+	i, seen := d.indexes[image.Name]
+	if !seen {
+		d.indexes[image.Name] = len(d.images)
+		d.images = append(d.images, image)
+		d.positions = append(d.positions, position)
+		return nil
+	}
+	if !reflect.DeepEqual(d.images[i], image) {
+		return fmt.Errorf("%s: %s is listed again with different attributes, first at %s", position, image.Name, d.positions[i])
+	}
+	return nil
+}
+
+func parseLine(line string) (Image, error) {
+	fields := strings.Fields(line)
+	name, err := ParseReference(fields[0])
+	if err != nil {
+		return Image{}, err
+	}
+	image := Image{Name: name}
+	for _, attribute := range fields[1:] {
+		key, value, found := strings.Cut(attribute, "=")
+		if !found {
+			return image, fmt.Errorf("attribute %q is not of the form key=value", attribute)
+		}
+		if err := image.setAttribute(key, value); err != nil {
+			return image, err
+		}
+	}
+	return image, image.resolveExpectedDigest()
+}
+
+// resolveExpectedDigest fills ExpectedDigest from the name, if the name is a digest reference.
+func (i *Image) resolveExpectedDigest() error {
+	digest := nameDigest(i.Name)
+	if digest == "" {
+		return nil
+	}
+	if _, err := ParseDigest(digest); err != nil {
+		return err
+	}
+	if i.ExpectedDigest != "" && i.ExpectedDigest != digest {
+		return fmt.Errorf("expected digest %s conflicts with digest %s in image name", i.ExpectedDigest, digest)
+	}
+	i.ExpectedDigest = digest
+	return nil
+}
+
+func (i *Image) setAttribute(key, value string) error {
+	var err error
+	switch key {
+	case "pullPolicy":
+		i.PullPolicy, err = ParsePullPolicy(value)
+	case "expectedDigest":
+		i.ExpectedDigest, err = ParseDigest(value)
+	case "runtimeHandlers":
+		i.RuntimeHandlers, err = ParseRuntimeHandlers(value)
+	case "sandboxNamespace":
+		i.SandboxNamespace, err = ParseNamespace(value)
+	case "nodeSelector":
+		i.NodeSelector, err = ParseNodeSelector(value)
+	case "platform":
+		i.Platform, err = ParsePlatform(value)
+	case "required":
+		var required bool
+		required, err = strconv.ParseBool(value)
+		if err != nil {
+			err = fmt.Errorf("invalid required value %q, expected true or false", value)
+		}
+		i.Required = &required
+	case "priority":
+		i.Priority, err = strconv.Atoi(value)
+		if err != nil {
+			err = fmt.Errorf("invalid priority %q, expected an integer", value)
+		}
+	default:
+		err = fmt.Errorf("unknown attribute %q", key)
+	}
+	return err
+}
+
+// Merge appends the extra images to the list, skipping those whose normalized name is already present,
+// so that entries of the list keep their attributes.
+func Merge(images []Image, extra ...Image) []Image {
+	seen := make(map[string]bool, len(images))
+	for _, image := range images {
+		seen[Normalize(image.Name)] = true
+	}
+	for _, image := range extra {
+		if name := Normalize(image.Name); !seen[name] {
+			seen[name] = true
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+// ParseNames creates a list of images with just names, normalized, and no attributes.
+// Unlike FromNames, it rejects invalid names.
+func ParseNames(names ...string) ([]Image, error) {
+	for _, name := range names {
+		if _, err := ParseReference(name); err != nil {
+			return nil, err
+		}
+	}
+	return FromNames(names...), nil
+}
+
+// FromNames creates a list of images with just names, normalized, and no attributes.
+// Invalid names are kept as they are, and left for the runtime to reject.
+func FromNames(names ...string) []Image {
+	images := make([]Image, 0, len(names))
+	for _, name := range names {
+		image := Image{Name: Normalize(name)}
+		// Unparseable digests are left for the runtime to reject.
+		_ = image.resolveExpectedDigest()
+		images = append(images, image)
+	}
+	return images
+}
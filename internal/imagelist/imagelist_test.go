@@ -0,0 +1,341 @@
+package imagelist
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	sha      = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	otherSha = "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		input       string
+		expected    []Image
+		expectedErr string
+	}{
+		"empty": {
+			input: "",
+		},
+		"names, comments and blank lines": {
+			input: "# a comment\n\ndebian:latest\n  quay.io/strimzi/kafka:latest-kafka-3.7.0  \n",
+			expected: []Image{
+				{Name: "docker.io/library/debian:latest"},
+				{Name: "quay.io/strimzi/kafka:latest-kafka-3.7.0"},
+			},
+		},
+		"pull policy": {
+			input: "debian:latest pullPolicy=IfNotPresent\nnginx\tpullPolicy=Always\n",
+			expected: []Image{
+				{Name: "docker.io/library/debian:latest", PullPolicy: PullIfNotPresent},
+				{Name: "docker.io/library/nginx:latest", PullPolicy: PullAlways},
+			},
+		},
+		"bad pull policy": {
+			input:       "debian:latest\nnginx pullPolicy=Never\n",
+			expectedErr: `line 2: unknown pull policy "Never"`,
+		},
+		"expected digest": {
+			input: "debian:12 expectedDigest=sha256:" + sha + "\ndebian@sha256:" + sha + "\ndebian:12@sha256:" + sha + " expectedDigest=sha256:" + sha + "\n",
+			expected: []Image{
+				{Name: "docker.io/library/debian:12", ExpectedDigest: "sha256:" + sha},
+				{Name: "docker.io/library/debian@sha256:" + sha, ExpectedDigest: "sha256:" + sha},
+				{Name: "docker.io/library/debian:12@sha256:" + sha, ExpectedDigest: "sha256:" + sha},
+			},
+		},
+		"bad expected digest": {
+			input:       "debian:12 expectedDigest=sha256:abc",
+			expectedErr: `line 1: invalid digest "sha256:abc"`,
+		},
+		"conflicting expected digest": {
+			input:       "debian@sha256:" + sha + " expectedDigest=sha256:" + otherSha,
+			expectedErr: "line 1: expected digest sha256:" + otherSha + " conflicts with digest sha256:" + sha + " in image name",
+		},
+		"runtime handlers": {
+			input: "nginx runtimeHandlers=kata\ndebian runtimeHandlers=kata,runc\n",
+			expected: []Image{
+				{Name: "docker.io/library/nginx:latest", RuntimeHandlers: []string{"kata"}},
+				{Name: "docker.io/library/debian:latest", RuntimeHandlers: []string{"kata", "runc"}},
+			},
+		},
+		"invalid sandbox label": {
+			input:       "- name: nginx\n  sandboxLabels: {\"bad key\": a}\n",
+			expectedErr: "image 1 (nginx): sandboxLabels: Invalid value: \"bad key\"",
+		},
+		"invalid sandbox annotation": {
+			input:       "- name: nginx\n  sandboxAnnotations: {\"bad key\": a}\n",
+			expectedErr: `image 1 (nginx): invalid sandbox annotation key "bad key"`,
+		},
+		"empty runtime handler": {
+			input:       "nginx runtimeHandlers=kata,",
+			expectedErr: `line 1: empty runtime handler name in "kata,"`,
+		},
+		"sandbox namespace": {
+			input:    "nginx sandboxNamespace=my-app",
+			expected: []Image{{Name: "docker.io/library/nginx:latest", SandboxNamespace: "my-app"}},
+		},
+		"bad sandbox namespace": {
+			input:       "nginx sandboxNamespace=My_App",
+			expectedErr: `line 1: invalid namespace "My_App"`,
+		},
+		"priority": {
+			input:    "nginx priority=10\nbusybox priority=-1",
+			expected: []Image{{Name: "docker.io/library/nginx:latest", Priority: 10}, {Name: "docker.io/library/busybox:latest", Priority: -1}},
+		},
+		"bad priority": {
+			input:       "nginx priority=high",
+			expectedErr: `line 1: invalid priority "high"`,
+		},
+		"required": {
+			input:    "nginx required=true\nbusybox required=false",
+			expected: []Image{{Name: "docker.io/library/nginx:latest", Required: ptr(true)}, {Name: "docker.io/library/busybox:latest", Required: ptr(false)}},
+		},
+		"bad required": {
+			input:       "nginx required=maybe",
+			expectedErr: `line 1: invalid required value "maybe"`,
+		},
+		"platform": {
+			input:    "nginx platform=linux/arm64",
+			expected: []Image{{Name: "docker.io/library/nginx:latest", Platform: "linux/arm64"}},
+		},
+		"bad platform": {
+			input:       "nginx platform=linux/arm64/v8",
+			expectedErr: `line 1: invalid platform "linux/arm64/v8"`,
+		},
+		"unknown attribute": {
+			input:       "nginx color=blue",
+			expectedErr: `line 1: unknown attribute "color"`,
+		},
+		"not an attribute": {
+			input:       "nginx debian",
+			expectedErr: `line 1: attribute "debian" is not of the form key=value`,
+		},
+		"invalid reference": {
+			input:       "nginx\n\nquay.io/Example/app:v1\n",
+			expectedErr: `line 3: invalid image reference "quay.io/Example/app:v1"`,
+		},
+		"duplicates": {
+			input: "nginx priority=1\nquay.io/example/app\ndocker.io/library/nginx:latest priority=1\nindex.docker.io/nginx priority=1\n",
+			expected: []Image{
+				{Name: "docker.io/library/nginx:latest", Priority: 1},
+				{Name: "quay.io/example/app:latest"},
+			},
+		},
+		"conflicting duplicates": {
+			input:       "nginx priority=1\ndocker.io/library/nginx:latest priority=2\n",
+			expectedErr: "line 2: docker.io/library/nginx:latest is listed again with different attributes, first at line 1",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			actual, err := Parse([]byte(test.input))
+			if test.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	images, err := LoadFile("")
+	require.NoError(t, err)
+	assert.Empty(t, images)
+
+	fileName := filepath.Join(t.TempDir(), "images.txt")
+	require.NoError(t, os.WriteFile(fileName, []byte("nginx pullPolicy=Sometimes\n"), 0o600))
+	_, err = LoadFile(fileName)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), fileName+": line 1:")
+}
+
+func TestFromNames(t *testing.T) {
+	assert.Equal(t, []Image{
+		{Name: "docker.io/library/nginx:latest"},
+		{Name: "docker.io/library/nginx@sha256:" + sha, ExpectedDigest: "sha256:" + sha},
+	}, FromNames("nginx", "nginx@sha256:"+sha))
+}
+
+func TestMerge(t *testing.T) {
+	list := []Image{{Name: "nginx", Priority: 1}, {Name: "redis"}}
+	assert.Equal(t, []Image{
+		{Name: "nginx", Priority: 1},
+		{Name: "redis"},
+		{Name: "docker.io/library/postgres:latest"},
+	}, Merge(list, FromNames("nginx", "postgres", "redis", "postgres")...))
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestParseStructured(t *testing.T) {
+	tests := map[string]struct {
+		input       string
+		expected    []Image
+		expectedErr string
+	}{
+		"yaml with all fields": {
+			input: `# leading comment
+images:
+- name: quay.io/example/critical:v2
+  priority: 10
+  required: false
+  pullPolicy: IfNotPresent
+  expectedDigest: sha256:` + sha + `
+  runtimeHandlers: [kata, runc]
+  sandboxNamespace: my-app
+  sandboxLabels: {team: a}
+  sandboxAnnotations: {example.com/snapshotter: stargz}
+  platform: linux/arm64
+  timeouts:
+    initialPullAttempt: 1m
+    maxPullAttempt: 10m
+- name: debian@sha256:` + sha + `
+`,
+			expected: []Image{
+				{
+					Name:                      "quay.io/example/critical:v2",
+					Priority:                  10,
+					Required:                  ptr(false),
+					PullPolicy:                PullIfNotPresent,
+					ExpectedDigest:            "sha256:" + sha,
+					RuntimeHandlers:           []string{"kata", "runc"},
+					SandboxNamespace:          "my-app",
+					SandboxLabels:             map[string]string{"team": "a"},
+					SandboxAnnotations:        map[string]string{"example.com/snapshotter": "stargz"},
+					Platform:                  "linux/arm64",
+					InitialPullAttemptTimeout: time.Minute,
+					MaxPullAttemptTimeout:     10 * time.Minute,
+				},
+				{Name: "docker.io/library/debian@sha256:" + sha, ExpectedDigest: "sha256:" + sha},
+			},
+		},
+		"yaml list": {
+			input:    "- name: nginx\n- name: debian\n  priority: 1\n",
+			expected: []Image{{Name: "docker.io/library/nginx:latest"}, {Name: "docker.io/library/debian:latest", Priority: 1}},
+		},
+		"json": {
+			input:    `{"images": [{"name": "nginx", "required": true}]}`,
+			expected: []Image{{Name: "docker.io/library/nginx:latest", Required: ptr(true)}},
+		},
+		"json list": {
+			input:    `[{"name": "nginx", "pullPolicy": "Always"}]`,
+			expected: []Image{{Name: "docker.io/library/nginx:latest", PullPolicy: PullAlways}},
+		},
+		"empty images": {
+			input:    "images: []",
+			expected: []Image{},
+		},
+		"unknown field": {
+			input:       "images:\n- name: nginx\n  pullPolicies: Always\n",
+			expectedErr: `unknown field "pullPolicies"`,
+		},
+		"missing name": {
+			input:       "images:\n- priority: 1\n",
+			expectedErr: "image 1 (): missing name",
+		},
+		"bad pull policy": {
+			input:       "- name: nginx\n- name: debian\n  pullPolicy: Never\n",
+			expectedErr: `image 2 (debian): unknown pull policy "Never"`,
+		},
+		"bad platform": {
+			input:       "- name: nginx\n  platform: arm64\n",
+			expectedErr: `image 1 (nginx): invalid platform "arm64"`,
+		},
+		"bad timeout": {
+			input:       "- name: nginx\n  timeouts:\n    maxPullAttempt: forever\n",
+			expectedErr: `invalid duration "forever"`,
+		},
+		"empty runtime handler": {
+			input:       "- name: nginx\n  runtimeHandlers: [\"\"]\n",
+			expectedErr: "image 1 (nginx): empty runtime handler name",
+		},
+		"invalid reference": {
+			input:       "# comment\nimages:\n- name: nginx\n- name: quay.io/example/app:-v1\n  priority: 1\n",
+			expectedErr: `line 4: image 2 (quay.io/example/app:-v1): invalid image reference "quay.io/example/app:-v1": invalid tag "-v1"`,
+		},
+		"invalid reference in json": {
+			input:       "[\n  {\"name\": \"nginx\"},\n  {\"name\": \"Nginx\"}\n]",
+			expectedErr: "line 3: image 2 (Nginx): invalid image reference",
+		},
+		"duplicates": {
+			input:    "- name: nginx\n- name: docker.io/library/nginx\n",
+			expected: []Image{{Name: "docker.io/library/nginx:latest"}},
+		},
+		"conflicting duplicates": {
+			input:       "- name: nginx\n- name: docker.io/library/nginx\n  required: true\n",
+			expectedErr: "line 2: image 2 (docker.io/library/nginx): docker.io/library/nginx:latest is listed again with different attributes, first at line 1: image 1 (nginx)",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			images, err := Parse([]byte(test.input))
+			if test.expectedErr != "" {
+				assert.ErrorContains(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, images)
+		})
+	}
+}
+
+func TestParseNodeSelectors(t *testing.T) {
+	tests := map[string]struct {
+		input            string
+		expectedSelector string
+		expectedErr      string
+	}{
+		"text": {
+			input:            "cuda nodeSelector=nvidia.com/gpu.present=true,kubernetes.io/arch!=arm64",
+			expectedSelector: "kubernetes.io/arch!=arm64,nvidia.com/gpu.present=true",
+		},
+		"text exists": {
+			input:            "cuda nodeSelector=nvidia.com/gpu.present",
+			expectedSelector: "nvidia.com/gpu.present",
+		},
+		"bad text": {
+			input:       "cuda nodeSelector=a==b==c",
+			expectedErr: `line 1: invalid node selector "a==b==c"`,
+		},
+		"structured": {
+			input: `images:
+- name: cuda
+  nodeSelector:
+    matchLabels:
+      nvidia.com/gpu.present: "true"
+    matchExpressions:
+    - {key: node.kubernetes.io/instance-type, operator: In, values: [g5.xlarge, g5.2xlarge]}
+`,
+			expectedSelector: "node.kubernetes.io/instance-type in (g5.2xlarge,g5.xlarge),nvidia.com/gpu.present=true",
+		},
+		"bad structured": {
+			input:       "- name: cuda\n  nodeSelector:\n    matchExpressions:\n    - {key: gpu, operator: Sometimes}\n",
+			expectedErr: "image 1 (cuda): invalid node selector",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			images, err := Parse([]byte(test.input))
+			if test.expectedErr != "" {
+				assert.ErrorContains(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, images, 1)
+			require.NotNil(t, images[0].NodeSelector)
+			assert.Equal(t, test.expectedSelector, images[0].NodeSelector.String())
+		})
+	}
+}
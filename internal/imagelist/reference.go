@@ -0,0 +1,102 @@
+package imagelist
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	dockerHubDomain = "docker.io"
+	officialRepo    = "library/"
+	defaultTag      = "latest"
+	// maxNameLength is the maximum length of the name part of a reference, without tag and digest.
+	maxNameLength = 255
+)
+
+// Regular expressions for the reference grammar of the OCI distribution project, which container runtimes follow.
+var (
+	domainComponent = `(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])`
+	domainRegexp    = regexp.MustCompile(`^(?:` + domainComponent + `(?:\.` + domainComponent + `)*|\[[a-fA-F0-9:]+\])(?::[0-9]+)?$`)
+	pathRegexp      = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	tagRegexp       = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	// Digests of algorithms other than those in digestRegexp are valid references, but cannot be expected digests.
+	referenceDigestRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}$`)
+)
+
+// ParseReference validates an image reference and returns its canonical form, following the docker conventions
+// also used by container runtimes: references without a registry are on Docker Hub, single-component Docker Hub
+// repositories are official images in the library repository, and references without a tag or digest refer to
+// the latest tag. For example, nginx becomes docker.io/library/nginx:latest.
+func ParseReference(s string) (string, error) {
+	remainder, digest, hasDigest := strings.Cut(s, "@")
+	if hasDigest && !referenceDigestRegexp.MatchString(digest) {
+		return "", fmt.Errorf("invalid image reference %q: invalid digest %q", s, digest)
+	}
+	name, tag := remainder, ""
+	if i := strings.LastIndex(remainder, ":"); i > strings.LastIndex(remainder, "/") {
+		name, tag = remainder[:i], remainder[i+1:]
+		if !tagRegexp.MatchString(tag) {
+			return "", fmt.Errorf("invalid image reference %q: invalid tag %q", s, tag)
+		}
+	}
+	if len(name) > maxNameLength {
+		return "", fmt.Errorf("invalid image reference %q: name longer than %d characters", s, maxNameLength)
+	}
+	domain, path := splitDomain(name)
+	if !domainRegexp.MatchString(domain) {
+		return "", fmt.Errorf("invalid image reference %q: invalid registry %q", s, domain)
+	}
+	if !pathRegexp.MatchString(path) {
+		if strings.ToLower(path) != path {
+			return "", fmt.Errorf("invalid image reference %q: repository name must be lowercase", s)
+		}
+		return "", fmt.Errorf("invalid image reference %q: invalid repository %q", s, path)
+	}
+	if domain == dockerHubDomain && !strings.Contains(path, "/") {
+		path = officialRepo + path
+	}
+	normalized := domain + "/" + path
+	if tag != "" {
+		normalized += ":" + tag
+	} else if !hasDigest {
+		normalized += ":" + defaultTag
+	}
+	if hasDigest {
+		normalized += "@" + digest
+	}
+	return normalized, nil
+}
+
+// Normalize returns the canonical form of an image reference as ParseReference does,
+// or the reference unchanged if it is invalid.
+func Normalize(s string) string {
+	if normalized, err := ParseReference(s); err == nil {
+		return normalized
+	}
+	return s
+}
+
+// WithDigest returns a reference to the given digest in the repository of the image reference, without its tag
+// and digest, if any. For example, quay.io/example/app:v1 and sha256:abc become quay.io/example/app@sha256:abc.
+func WithDigest(s, digest string) string {
+	name, _, _ := strings.Cut(s, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	return name + "@" + digest
+}
+
+// splitDomain splits an image name without tag and digest into its registry domain and repository path.
+// The first component is a domain only if it looks like a host name, rather than a Docker Hub repository.
+func splitDomain(name string) (string, string) {
+	first, rest, found := strings.Cut(name, "/")
+	if !found || (!strings.ContainsAny(first, ".:") && first != "localhost" && strings.ToLower(first) == first) {
+		return dockerHubDomain, name
+	}
+	switch first {
+	case "index.docker.io", "registry-1.docker.io":
+		first = dockerHubDomain
+	}
+	return first, rest
+}
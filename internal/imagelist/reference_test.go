@@ -0,0 +1,63 @@
+package imagelist
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReference(t *testing.T) {
+	digest := "sha256:" + sha
+	tests := map[string]struct {
+		expected    string
+		expectedErr string
+	}{
+		"nginx":                                         {expected: "docker.io/library/nginx:latest"},
+		"nginx:1.27":                                    {expected: "docker.io/library/nginx:1.27"},
+		"bitnami/redis":                                 {expected: "docker.io/bitnami/redis:latest"},
+		"docker.io/library/nginx:latest":                {expected: "docker.io/library/nginx:latest"},
+		"index.docker.io/nginx":                         {expected: "docker.io/library/nginx:latest"},
+		"quay.io/example/app":                           {expected: "quay.io/example/app:latest"},
+		"localhost/app:v1":                              {expected: "localhost/app:v1"},
+		"registry.local:5000/a/b_c/d--e":                {expected: "registry.local:5000/a/b_c/d--e:latest"},
+		"[::1]:5000/app:v1":                             {expected: "[::1]:5000/app:v1"},
+		"quay.io/example/app@" + digest:                 {expected: "quay.io/example/app@" + digest},
+		"quay.io/example/app:v1@" + digest:              {expected: "quay.io/example/app:v1@" + digest},
+		"nginx@" + digest:                               {expected: "docker.io/library/nginx@" + digest},
+		"Nginx":                                         {expectedErr: "repository name must be lowercase"},
+		"quay.io/Example/app":                           {expectedErr: "repository name must be lowercase"},
+		"quay.io/example/app:":                          {expectedErr: `invalid tag ""`},
+		"quay.io/example/app:-v1":                       {expectedErr: `invalid tag "-v1"`},
+		"quay.io/example/app@sha256:abc":                {expectedErr: `invalid digest "sha256:abc"`},
+		"quay.io/example//app":                          {expectedErr: `invalid repository "example//app"`},
+		"quay.io/":                                      {expectedErr: `invalid repository ""`},
+		"-quay.io/app":                                  {expectedErr: `invalid registry "-quay.io"`},
+		"https://quay.io/app":                           {expectedErr: `invalid registry "https:"`},
+		"quay.io/" + strings.Repeat("a", maxNameLength): {expectedErr: "name longer than 255 characters"},
+	}
+	for reference, test := range tests {
+		t.Run(reference, func(t *testing.T) {
+			actual, err := ParseReference(reference)
+			if test.expectedErr != "" {
+				assert.ErrorContains(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, actual)
+			assert.Equal(t, test.expected, Normalize(reference))
+		})
+	}
+}
+
+func TestNormalizeInvalid(t *testing.T) {
+	assert.Equal(t, "Not A Reference", Normalize("Not A Reference"))
+}
+
+func TestWithDigest(t *testing.T) {
+	digest := "sha256:" + sha
+	assert.Equal(t, "quay.io/example/app@"+digest, WithDigest("quay.io/example/app:v1", digest))
+	assert.Equal(t, "quay.io/example/app@"+digest, WithDigest("quay.io/example/app:v1@sha256:"+strings.Repeat("0", 64), digest))
+	assert.Equal(t, "registry.local:5000/app@"+digest, WithDigest("registry.local:5000/app", digest))
+}
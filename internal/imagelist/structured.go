@@ -0,0 +1,180 @@
+package imagelist
+
+import (
+	"fmt"
+	"strings"
+
+	yamlv3 "go.yaml.in/yaml/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+)
+
+// structuredList is the YAML or JSON form of the image list. Unlike the text format it can hold every
+// per-image setting. It is either an object with an images key, or just the list of images.
+type structuredList struct {
+	Images []structuredImage `json:"images"`
+}
+
+type structuredImage struct {
+	Name               string                `json:"name"`
+	Priority           int                   `json:"priority,omitempty"`
+	Required           *bool                 `json:"required,omitempty"`
+	PullPolicy         string                `json:"pullPolicy,omitempty"`
+	ExpectedDigest     string                `json:"expectedDigest,omitempty"`
+	RuntimeHandlers    []string              `json:"runtimeHandlers,omitempty"`
+	SandboxNamespace   string                `json:"sandboxNamespace,omitempty"`
+	SandboxLabels      map[string]string     `json:"sandboxLabels,omitempty"`
+	SandboxAnnotations map[string]string     `json:"sandboxAnnotations,omitempty"`
+	Platform           string                `json:"platform,omitempty"`
+	NodeSelector       *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	Timeouts           structuredTimeouts    `json:"timeouts,omitempty"`
+}
+
+type structuredTimeouts struct {
+	InitialPullAttempt metav1.Duration `json:"initialPullAttempt,omitempty"`
+	MaxPullAttempt     metav1.Duration `json:"maxPullAttempt,omitempty"`
+}
+
+// isStructured tells the structured format from the text format by the first line which is not blank or a comment.
+func isStructured(data []byte) bool {
+	line := firstSignificantLine(data)
+	return strings.HasPrefix(line, "{") || strings.HasPrefix(line, "images:") || isStructuredList(line)
+}
+
+// isStructuredList returns whether the first significant line starts a bare list of images, in JSON or YAML.
+func isStructuredList(line string) bool {
+	return strings.HasPrefix(line, "[") || strings.HasPrefix(line, "-")
+}
+
+func firstSignificantLine(data []byte) string {
+	for line := range strings.Lines(string(data)) {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return line
+		}
+	}
+	return ""
+}
+
+func parseStructured(data []byte) ([]Image, error) {
+	var list structuredList
+	var err error
+	if isStructuredList(firstSignificantLine(data)) {
+		err = yaml.UnmarshalStrict(data, &list.Images)
+	} else {
+		err = yaml.UnmarshalStrict(data, &list)
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines := structuredImageLines(data, len(list.Images))
+	images := deduplicator{images: make([]Image, 0, len(list.Images))}
+	for i, structured := range list.Images {
+		position := fmt.Sprintf("image %d (%s)", i+1, structured.Name)
+		if lines[i] > 0 {
+			position = fmt.Sprintf("line %d: %s", lines[i], position)
+		}
+		image, err := structured.toImage()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", position, err)
+		}
+		if err := images.add(image, position); err != nil {
+			return nil, err
+		}
+	}
+	return images.images, nil
+}
+
+// structuredImageLines returns the line numbers where each of the count images starts, or zeros where unknown.
+func structuredImageLines(data []byte, count int) []int {
+	lines := make([]int, count)
+	var document yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &document); err != nil || len(document.Content) == 0 {
+		return lines
+	}
+	node := document.Content[0]
+	if node.Kind == yamlv3.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == "images" {
+				node = node.Content[i+1]
+				break
+			}
+		}
+	}
+	if node.Kind != yamlv3.SequenceNode || len(node.Content) != count {
+		return lines
+	}
+	for i, item := range node.Content {
+		lines[i] = item.Line
+	}
+	return lines
+}
+
+func (s structuredImage) toImage() (Image, error) {
+	image := Image{
+		Name:                      s.Name,
+		Priority:                  s.Priority,
+		Required:                  s.Required,
+		RuntimeHandlers:           s.RuntimeHandlers,
+		InitialPullAttemptTimeout: s.Timeouts.InitialPullAttempt.Duration,
+		MaxPullAttemptTimeout:     s.Timeouts.MaxPullAttempt.Duration,
+	}
+	var err error
+	if image.Name == "" {
+		return image, fmt.Errorf("missing name")
+	}
+	if image.Name, err = ParseReference(image.Name); err != nil {
+		return image, err
+	}
+	if s.PullPolicy != "" {
+		if image.PullPolicy, err = ParsePullPolicy(s.PullPolicy); err != nil {
+			return image, err
+		}
+	}
+	if s.ExpectedDigest != "" {
+		if image.ExpectedDigest, err = ParseDigest(s.ExpectedDigest); err != nil {
+			return image, err
+		}
+	}
+	for _, handler := range s.RuntimeHandlers {
+		if handler == "" {
+			return image, fmt.Errorf("empty runtime handler name")
+		}
+	}
+	if s.SandboxNamespace != "" {
+		if image.SandboxNamespace, err = ParseNamespace(s.SandboxNamespace); err != nil {
+			return image, err
+		}
+	}
+	if len(s.SandboxLabels) > 0 {
+		if errs := metav1validation.ValidateLabels(s.SandboxLabels, field.NewPath("sandboxLabels")); len(errs) > 0 {
+			return image, errs.ToAggregate()
+		}
+		image.SandboxLabels = s.SandboxLabels
+	}
+	if len(s.SandboxAnnotations) > 0 {
+		for key := range s.SandboxAnnotations {
+			if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+				return image, fmt.Errorf("invalid sandbox annotation key %q: %s", key, strings.Join(errs, "; "))
+			}
+		}
+		image.SandboxAnnotations = s.SandboxAnnotations
+	}
+	if s.Platform != "" {
+		if image.Platform, err = ParsePlatform(s.Platform); err != nil {
+			return image, err
+		}
+	}
+	if s.NodeSelector != nil {
+		if image.NodeSelector, err = metav1.LabelSelectorAsSelector(s.NodeSelector); err != nil {
+			return image, fmt.Errorf("invalid node selector: %w", err)
+		}
+	}
+	if image.InitialPullAttemptTimeout < 0 || image.MaxPullAttemptTimeout < 0 {
+		return image, fmt.Errorf("negative timeout")
+	}
+	return image, image.resolveExpectedDigest()
+}
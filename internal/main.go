@@ -1,22 +1,28 @@
 package internal
 
 import (
+	"cmp"
 	"context"
-	"encoding/json"
 	"fmt"
 	"log/slog"
-	"os"
+	"maps"
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/stackrox/image-prefetcher/internal/coordination"
 	"github.com/stackrox/image-prefetcher/internal/credentialprovider"
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
 	metricsProto "github.com/stackrox/image-prefetcher/internal/metrics/gen"
-	"github.com/stackrox/image-prefetcher/internal/metrics/submitter"
 	"github.com/stackrox/image-prefetcher/internal/nodelabels"
+	"github.com/stackrox/image-prefetcher/internal/pullerrors"
+	"github.com/stackrox/image-prefetcher/internal/registrylimits"
 
 	"github.com/google/uuid"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"k8s.io/apimachinery/pkg/labels"
 	criV1 "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
@@ -27,73 +33,254 @@ type TimingConfig struct {
 	OverallTimeout            time.Duration
 	InitialPullAttemptDelay   time.Duration
 	MaxPullAttemptDelay       time.Duration
+	// ReportingTimeout bounds the reporting phase of a run, which follows the pull phase bounded by OverallTimeout.
+	ReportingTimeout time.Duration
+	// TerminationGracePeriod is the time left for reporting results after a termination signal interrupted pulls.
+	TerminationGracePeriod time.Duration
 }
 
-func Run(logger *slog.Logger, criSocketPath string, dockerConfigJSONPath string, credentialProviderConfig string, credentialProviderBinDir string, timing TimingConfig, metricsEndpoint string, imageNames ...string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timing.OverallTimeout)
-	defer cancel()
+// Config holds the settings of a single fetch run.
+type Config struct {
+	CRISocketPath            string
+	DockerConfigJSONPath     string
+	CredentialProviderConfig string
+	CredentialProviderBinDir string
+	MetricsEndpoint          string
+	Timing                   TimingConfig
+	// MaxParallelPulls bounds the number of pulls in flight at once. Zero means no limit.
+	MaxParallelPulls int
+	// RegistryLimits further bounds pulls per registry host.
+	RegistryLimits registrylimits.Config
+	Coordination   CoordinationConfig
+	// PullPolicy applies to images which do not specify their own.
+	PullPolicy imagelist.PullPolicy
+	// RuntimeHandlers to pull images for, for images which do not specify their own.
+	// Empty means just the runtime's default handler.
+	RuntimeHandlers []string
+	// PullOrder determines the order in which pulls are started. Only matters if the number of parallel pulls is limited.
+	PullOrder PullOrder
+	// FailurePolicy determines whether failed pulls make Run return an error.
+	FailurePolicy FailurePolicy
+	Sandbox       SandboxConfig
+	// AnonymousFallback enables trying an anonymous pull after all credentials for an image were rejected.
+	AnonymousFallback bool
+	// ResolveTags pins image tags to digests resolved by the metrics endpoint, the same for all nodes.
+	ResolveTags bool
+	DiskSpace   DiskSpaceConfig
+}
 
-	criConn, err := grpc.NewClient("unix://"+criSocketPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		return fmt.Errorf("failed to dial CRI socket %q: %w", criSocketPath, err)
-	}
-	criClient := criV1.NewImageServiceClient(criConn)
+// SandboxConfig describes the synthetic pod sandbox passed along with pull requests, so that runtimes which
+// take it into account (e.g. for namespace-scoped registry configuration) pull images as they would for
+// a real pod. Zero value means no sandbox config is passed.
+type SandboxConfig struct {
+	// Namespace of the synthetic pod. Can be overridden per image.
+	Namespace   string
+	Labels      map[string]string
+	Annotations map[string]string
+}
 
-	if err := listImagesForDebugging(ctx, logger, criClient, timing.ImageListTimeout, "before"); err != nil {
-		return fmt.Errorf("failed to list images for debugging before pulling: %w", err)
+// podSandboxConfig returns the CRI sandbox config for an image, with the given per-image overrides: a non-empty
+// namespace replaces the configured one, and labels and annotations are merged over the configured ones.
+// Returns nil if neither configures anything.
+func (c SandboxConfig) podSandboxConfig(overrides SandboxConfig, uid string) *criV1.PodSandboxConfig {
+	namespace := cmp.Or(overrides.Namespace, c.Namespace)
+	if namespace == "" && len(c.Labels) == 0 && len(c.Annotations) == 0 && len(overrides.Labels) == 0 && len(overrides.Annotations) == 0 {
+		return nil
 	}
-
-	var metricsSink *submitter.Submitter
-	if metricsEndpoint != "" {
-		metricsConn, err := grpc.NewClient(metricsEndpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
-		if err != nil {
-			return fmt.Errorf("failed to dial metrics endpoint %q: %w", metricsEndpoint, err)
+	namespace = cmp.Or(namespace, "default")
+	const name = "image-prefetcher"
+	// Mimic labels set by kubelet on sandboxes of real pods.
+	sandboxLabels := map[string]string{
+		"io.kubernetes.pod.name":      name,
+		"io.kubernetes.pod.namespace": namespace,
+		"io.kubernetes.pod.uid":       uid,
+	}
+	maps.Copy(sandboxLabels, c.Labels)
+	maps.Copy(sandboxLabels, overrides.Labels)
+	var annotations map[string]string
+	if len(c.Annotations) > 0 || len(overrides.Annotations) > 0 {
+		annotations = maps.Clone(c.Annotations)
+		if annotations == nil {
+			annotations = make(map[string]string, len(overrides.Annotations))
 		}
-		metricsSink = submitter.NewSubmitter(logger, metricsProto.NewMetricsClient(metricsConn))
-		go func() { _ = metricsSink.Run(ctx) }() // Returned error is for testing, sink already handles errors.
+		maps.Copy(annotations, overrides.Annotations)
+	}
+	return &criV1.PodSandboxConfig{
+		Metadata: &criV1.PodSandboxMetadata{
+			Name:      name,
+			Uid:       uid,
+			Namespace: namespace,
+		},
+		Labels:      sandboxLabels,
+		Annotations: annotations,
 	}
+}
 
-	// Initialize credential provider plugin keyring if configured
-	pluginKr, err := credentialprovider.NewPluginKeyring(logger, credentialProviderConfig, credentialProviderBinDir)
+// CoordinationConfig configures the cluster-wide limit on nodes pulling at the same time.
+type CoordinationConfig struct {
+	// Slots is the maximum number of nodes pulling at once. Zero disables coordination.
+	Slots         int
+	LeaseDuration time.Duration
+	// SlotTimeout bounds waiting for a slot, separately from the overall timeout, which starts once a slot is held.
+	// Zero means no limit.
+	SlotTimeout time.Duration
+}
+
+func Run(logger *slog.Logger, config Config, images ...imagelist.Image) error {
+	timing := config.Timing
+	// A run has two phases, each with its own time budget: pulling images, and reporting results.
+	// Reporting must not be cut short by pulls running out of time, since that is when its results matter most.
+	// Termination signals interrupt pulls right away, but leave a grace period for reporting their results.
+	reportCtx, cancelReport := context.WithCancel(context.Background())
+	defer cancelReport()
+	interruptibleCtx, cancelPulls := context.WithCancelCause(context.Background())
+	defer cancelPulls(nil)
+	defer cancelOnTermination(logger, cancelPulls, cancelReport, timing.TerminationGracePeriod)()
+
+	f, err := newPrefetcher(logger, config)
 	if err != nil {
-		return fmt.Errorf("failed to initialize credential provider plugins: %w", err)
+		return err
 	}
 
-	kr := credentialprovider.BasicDockerKeyring{}
-	if err := loadPullSecret(logger, &kr, dockerConfigJSONPath); err != nil {
-		return fmt.Errorf("failed to load image pull secrets: %w", err)
+	if err := listImagesForDebugging(interruptibleCtx, logger, f.criClient, timing.ImageListTimeout, "before"); err != nil {
+		return fmt.Errorf("failed to list images for debugging before pulling: %w", err)
 	}
 
-	// Track results per image. Multiple goroutines (different auths) may update the same image.
-	var results sync.Map // map[string]bool (imageRef -> success status)
+	// Waiting for a pull slot does not count against the overall timeout, so that nodes which wait long
+	// still get their full time budget for pulling.
+	slot := acquirePullSlot(interruptibleCtx, logger, config.Coordination)
+	pullCtx, cancelPullTimeout := context.WithTimeout(interruptibleCtx, timing.OverallTimeout)
+	defer cancelPullTimeout()
 
-	var wg sync.WaitGroup
-	for _, imageName := range imageNames {
-		auths := getAuthsForImage(ctx, logger, pluginKr, &kr, imageName)
-		for i, auth := range auths {
-			wg.Add(1)
-			request := &criV1.PullImageRequest{
-				Image: &criV1.ImageSpec{
-					Image: imageName,
-				},
-				Auth: auth,
-			}
-			go pullImageWithRetries(ctx, pullLogger(logger, imageName, i, auth), &wg, criClient, metricsSink.Chan(), imageName, request, timing, &results)
-		}
-	}
-	wg.Wait()
-	logger.Info("pulling images finished")
+	metricsSink := f.startMetricsSink(reportCtx)
+
+	// Track results per image and runtime handler.
+	var results sync.Map  // map[pullTarget]nodelabels.Outcome
+	var failures sync.Map // map[pullTarget]pullerrors.Class
+
+	plan := f.planJobs(pullCtx, images, &results, metricsSink.Chan())
+	jobs := plan.jobs
+	p := f.newPuller(metricsSink.Chan(), &results, &failures)
+	pulls := f.preflightDiskSpace(pullCtx, plan, p)
+	logger.Info("starting to pull images", "jobs", len(pulls), "maxParallelPulls", config.MaxParallelPulls, "registryLimits", config.RegistryLimits)
+	runPullWorkers(pullCtx, config.MaxParallelPulls, f.registryLimiter, pulls, p.pullImage)
+	logger.Info("pulling images finished", "error", context.Cause(pullCtx))
+
+	reportingTimer := time.AfterFunc(timing.ReportingTimeout, cancelReport)
+	defer reportingTimer.Stop()
+	releasePullSlot(reportCtx, logger, slot)
+	f.sampleImageFsUsage(reportCtx, fsStageAfter, 0)
 	metricsSink.Await()
 
 	// Don't fail the overall operation if node labeling fails.
-	if err := nodelabels.PatchNodeLabels(ctx, &results, logger); err != nil {
+	if err := nodelabels.PatchNodeLabels(reportCtx, &results, logger); err != nil {
 		logger.Error("failed to update node labels", "error", err)
 	}
+	if err := nodelabels.RecordPulledImages(reportCtx, logger, pulledImages(&results), nil); err != nil {
+		logger.Error("failed to record pulled images", "error", err)
+	}
+	if err := nodelabels.RecordResolvedDigests(reportCtx, logger, plan.resolvedDigests); err != nil {
+		logger.Error("failed to record resolved digests", "error", err)
+	}
 
-	if err := listImagesForDebugging(ctx, logger, criClient, timing.ImageListTimeout, "after"); err != nil {
+	if err := listImagesForDebugging(reportCtx, logger, f.criClient, timing.ImageListTimeout, "after"); err != nil {
 		return fmt.Errorf("failed to list images for debugging after pulling: %w", err)
 	}
-	return nil
+	if interrupted(pullCtx) {
+		return fmt.Errorf("pulling images interrupted: %w", context.Cause(pullCtx))
+	}
+	return config.FailurePolicy.check(jobs, &failures)
+}
+
+// pulledImages returns the targets which were pulled, rather than found already present, according to results.
+func pulledImages(results *sync.Map) []nodelabels.PulledImage {
+	var pulled []nodelabels.PulledImage
+	results.Range(func(key, value any) bool {
+		if value.(nodelabels.Outcome) == nodelabels.OutcomePulled {
+			target := key.(pullTarget)
+			pulled = append(pulled, nodelabels.PulledImage{Image: target.image, RuntimeHandler: target.runtimeHandler})
+		}
+		return true
+	})
+	return pulled
+}
+
+// runtimeHandlers returns the runtime handlers to pull the image for.
+func runtimeHandlers(image imagelist.Image, config Config) []string {
+	if len(image.RuntimeHandlers) > 0 {
+		return image.RuntimeHandlers
+	}
+	if len(config.RuntimeHandlers) > 0 {
+		return config.RuntimeHandlers
+	}
+	return []string{""}
+}
+
+// platformMatches returns whether an image for the given os/arch platform applies to this node.
+// Empty platform matches any node.
+func platformMatches(platform string) bool {
+	return platform == "" || platform == runtime.GOOS+"/"+runtime.GOARCH
+}
+
+// nodeSelectorFilter returns a function telling whether an image is selected for this node by its node selector.
+// Node labels are only read if any image has a node selector. If they cannot be read, all images are selected,
+// since pulling images needlessly is better than missing them.
+func nodeSelectorFilter(ctx context.Context, logger *slog.Logger, images []imagelist.Image, getNodeLabels func(context.Context) (map[string]string, error)) func(imagelist.Image) bool {
+	selectAll := func(imagelist.Image) bool { return true }
+	if !slices.ContainsFunc(images, func(image imagelist.Image) bool { return image.NodeSelector != nil }) {
+		return selectAll
+	}
+	nodeLabels, err := getNodeLabels(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to get node labels, ignoring node selectors of images", "error", err)
+		return selectAll
+	}
+	return func(image imagelist.Image) bool {
+		return image.NodeSelector == nil || image.NodeSelector.Matches(labels.Set(nodeLabels))
+	}
+}
+
+// presentImage returns the status of the job's image if it is present in the runtime, with the expected digest
+// if any, or nil otherwise. An image present with a digest other than expected is treated as absent.
+func presentImage(ctx context.Context, job *pullJob, client criV1.ImageServiceClient, timeout time.Duration) *criV1.Image {
+	status := getImageStatus(ctx, job.logger, client, timeout, job.imageSpec())
+	if status == nil || verifyDigest(status, job.expectedDigest) != nil {
+		return nil
+	}
+	return status
+}
+
+// acquirePullSlot waits for a cluster-wide pull slot, if coordination is enabled, for at most the slot timeout.
+// Failures are logged rather than returned: pulls proceed without a slot rather than not at all.
+func acquirePullSlot(ctx context.Context, logger *slog.Logger, config CoordinationConfig) *coordination.Slot {
+	if config.Slots <= 0 {
+		return nil
+	}
+	if config.SlotTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.SlotTimeout)
+		defer cancel()
+	}
+	semaphore, err := coordination.NewSemaphoreFromEnvironment(logger, config.Slots, config.LeaseDuration)
+	if err != nil {
+		logger.WarnContext(ctx, "failed to set up pull coordination, pulling without it", "error", err)
+		return nil
+	}
+	slot, err := semaphore.Acquire(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to acquire pull slot, pulling without it", "error", err)
+		return nil
+	}
+	return slot
+}
+
+func releasePullSlot(ctx context.Context, logger *slog.Logger, slot *coordination.Slot) {
+	// Release even if the overall timeout already expired, otherwise others need to wait for the lease to expire.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if err := slot.Release(ctx); err != nil {
+		logger.WarnContext(ctx, "failed to release pull slot", "error", err)
+	}
 }
 
 func listImagesForDebugging(ctx context.Context, logger *slog.Logger, client criV1.ImageServiceClient, timeout time.Duration, stage string) error {
@@ -119,20 +306,34 @@ func loadPullSecret(logger *slog.Logger, kr *credentialprovider.BasicDockerKeyri
 		logger.Info("no image pull secret path provided, will pull without credentials")
 		return nil
 	}
-	f, err := os.ReadFile(dockerConfigJSONPath)
+	auths, err := credentialprovider.ReadDockerConfigJSONFile(dockerConfigJSONPath)
 	if err != nil {
-		return fmt.Errorf("failed read %q: %w", dockerConfigJSONPath, err)
+		return err
 	}
-	dockerConfigJSON := credentialprovider.DockerConfigJSON{}
-	if err := json.Unmarshal(f, &dockerConfigJSON); err != nil {
-		return fmt.Errorf("unmarshalling docker config failed: %w", err)
-	}
-	kr.Add(dockerConfigJSON.Auths)
+	kr.Add(auths)
 	return nil
 }
 
-func getAuthsForImage(ctx context.Context, logger *slog.Logger, pluginKr *credentialprovider.PluginKeyring, kr credentialprovider.DockerKeyring, imageName string) []*criV1.AuthConfig {
-	var auths []*criV1.AuthConfig
+// credentialSource names where pull credentials came from.
+type credentialSource string
+
+const (
+	credentialSourcePlugin     credentialSource = "plugin"
+	credentialSourcePullSecret credentialSource = "pull-secret"
+	credentialSourceAnonymous  credentialSource = "anonymous"
+)
+
+// credential is a candidate set of credentials for pulling an image.
+type credential struct {
+	source credentialSource
+	auth   *criV1.AuthConfig // nil for anonymous pulls
+}
+
+// getCredentialsForImage returns the credentials to try for the given image, in order of priority:
+// plugin credentials first, then pull secret ones. Anonymous access is tried last, if there are no
+// credentials at all, or if anonymousFallback is set.
+func getCredentialsForImage(ctx context.Context, logger *slog.Logger, pluginKr *credentialprovider.PluginKeyring, kr credentialprovider.DockerKeyring, imageName string, anonymousFallback bool) []credential {
+	var credentials []credential
 
 	// First, try plugin credentials
 	if pluginKr != nil {
@@ -144,7 +345,7 @@ func getAuthsForImage(ctx context.Context, logger *slog.Logger, pluginKr *creden
 					Username: creds.Username,
 					Password: creds.Password,
 				}
-				auths = append(auths, auth)
+				credentials = append(credentials, credential{source: credentialSourcePlugin, auth: auth})
 			}
 		}
 	}
@@ -160,95 +361,251 @@ func getAuthsForImage(ctx context.Context, logger *slog.Logger, pluginKr *creden
 			IdentityToken: creds.IdentityToken,
 			RegistryToken: creds.RegistryToken,
 		}
-		auths = append(auths, auth)
+		credentials = append(credentials, credential{source: credentialSourcePullSecret, auth: auth})
 	}
 
 	// If no credentials found at all, try un-authenticated pull
-	if len(auths) == 0 {
+	if len(credentials) == 0 {
 		logger.DebugContext(ctx, "no credentials present for image", "image", imageName)
-		auths = append(auths, nil)
+	}
+	if len(credentials) == 0 || anonymousFallback {
+		credentials = append(credentials, credential{source: credentialSourceAnonymous})
 	}
 
-	return auths
+	return credentials
 }
 
-func pullLogger(logger *slog.Logger, imageName string, authNum int, auth *criV1.AuthConfig) *slog.Logger {
-	if auth != nil {
-		return logger.With("image", imageName, "authNum", authNum, "authServer", auth.ServerAddress, "authUsername", auth.Username)
+func pullLogger(logger *slog.Logger, authNum int, cred credential) *slog.Logger {
+	logger = logger.With("authNum", authNum, "credentialSource", cred.source)
+	if cred.auth != nil {
+		return logger.With("authServer", cred.auth.ServerAddress, "authUsername", cred.auth.Username)
+	}
+	return logger
+}
+
+// puller pulls images and records their outcomes.
+type puller struct {
+	client          criV1.ImageServiceClient
+	registryLimiter *registrylimits.Limiter
+	metricsSink     chan<- *metricsProto.Result
+	timing          TimingConfig
+	results         *sync.Map       // map[pullTarget]nodelabels.Outcome
+	failures        *sync.Map       // map[pullTarget]pullerrors.Class, of the last error of failed pulls
+	diskSpace       *diskSpaceGuard // nil if free space is not checked
+}
+
+// pullImage pulls the image of the given job, trying its credentials in order.
+// It falls back to the next credential only if the previous one was rejected by the registry.
+func (p *puller) pullImage(ctx context.Context, job *pullJob, queueWait time.Duration) {
+	defer func() {
+		// The registry permit acquired for the job is unused if it is not pulled.
+		if release := job.takePermit(); release != nil {
+			release()
+		}
+	}()
+	job.logger.InfoContext(ctx, "image pull dequeued", "queueWait", queueWait)
+	if err := p.diskSpace.check(job.logger); err != nil {
+		p.failWithoutPulling(ctx, job, queueWait, err)
+		return
+	}
+	class := pullerrors.ClassUnknown
+	for i, cred := range job.credentials {
+		logger := pullLogger(job.logger, i, cred)
+		request := &criV1.PullImageRequest{
+			Image:         job.imageSpec(),
+			Auth:          cred.auth,
+			SandboxConfig: job.sandboxConfig,
+		}
+		err := p.pullImageWithRetries(ctx, logger, job, cred.source, request, queueWait)
+		if err == nil {
+			logger.InfoContext(ctx, "image pull succeeded", "credentialSource", cred.source)
+			p.results.Store(job.pullTarget, nodelabels.OutcomePulled)
+			return
+		}
+		class = pullerrors.Classify(err)
+		if class != pullerrors.ClassUnauthorized || ctx.Err() != nil {
+			break
+		}
+		if i+1 < len(job.credentials) {
+			logger.WarnContext(ctx, "credentials rejected, falling back to next ones", "nextCredentialSource", job.credentials[i+1].source)
+		}
+	}
+	if interrupted(ctx) {
+		job.logger.WarnContext(ctx, "image pull interrupted")
+		p.results.Store(job.pullTarget, nodelabels.OutcomeInterrupted)
+		return
 	}
-	return logger.With("image", imageName, "authNum", authNum)
+	job.logger.ErrorContext(ctx, "giving up pulling image", "errorClass", class)
+	p.results.Store(job.pullTarget, nodelabels.OutcomeFailed)
+	p.failures.Store(job.pullTarget, class)
 }
 
-func pullImageWithRetries(ctx context.Context, logger *slog.Logger, wg *sync.WaitGroup, client criV1.ImageServiceClient, metricsSink chan<- *metricsProto.Result, name string, request *criV1.PullImageRequest, timing TimingConfig, results *sync.Map) {
-	defer wg.Done()
-	attemptTimeout := timing.InitialPullAttemptTimeout
-	delay := timing.InitialPullAttemptDelay
+// failWithoutPulling records the job as failed for the given reason, without attempting to pull.
+func (p *puller) failWithoutPulling(ctx context.Context, job *pullJob, queueWait time.Duration, err error) {
+	class := pullerrors.Classify(err)
+	job.logger.ErrorContext(ctx, "not pulling image", "error", err, "errorClass", class)
+	noteFailure(p.metricsSink, job, "", time.Now(), 0, queueWait, err, class)
+	p.results.Store(job.pullTarget, nodelabels.OutcomeFailed)
+	p.failures.Store(job.pullTarget, class)
+}
+
+// pullImageWithRetries pulls the image until success, a permanent failure or ctx expiry.
+// Returns nil on success, and the last error otherwise.
+func (p *puller) pullImageWithRetries(ctx context.Context, logger *slog.Logger, job *pullJob, source credentialSource, request *criV1.PullImageRequest, queueWait time.Duration) error {
+	attemptTimeout := cmp.Or(job.initialPullAttemptTimeout, p.timing.InitialPullAttemptTimeout)
+	maxAttemptTimeout := cmp.Or(job.maxPullAttemptTimeout, p.timing.MaxPullAttemptTimeout)
+	delay := p.timing.InitialPullAttemptDelay
 	for {
-		logger.Info("attempting image pull", "timeout", attemptTimeout)
-		attemptCtx, cancel := context.WithTimeout(ctx, attemptTimeout)
-		start := time.Now()
-		response, err := client.PullImage(attemptCtx, request)
-		elapsed := time.Since(start)
-		cancel()
+		response, start, elapsed, err := pullImageOnce(ctx, logger, p.client, p.registryLimiter, job.takePermit(), request, attemptTimeout)
 		if err == nil {
 			logger.InfoContext(ctx, "image pulled successfully", "response", response, "elapsed", elapsed)
-			sizeBytes := getImageSize(ctx, logger, client, response)
-			noteSuccess(metricsSink, name, start, elapsed, sizeBytes)
-			// Always store success, overwriting any previous failure from another auth.
-			results.Store(name, true)
-			return
+			status := getImageStatus(ctx, logger, p.client, p.timing.ImageListTimeout, &criV1.ImageSpec{Image: response.ImageRef, RuntimeHandler: job.runtimeHandler})
+			err = verifyDigest(status, job.expectedDigest)
+			if err == nil {
+				noteSuccess(p.metricsSink, job, source, start, elapsed, queueWait, status.GetSize())
+				return nil
+			}
 		}
-		logger.ErrorContext(ctx, "image failed to pull", "error", err, "timeout", attemptTimeout, "elapsed", elapsed)
-		noteFailure(metricsSink, name, start, elapsed, err)
+		class := pullerrors.Classify(err)
+		logger.ErrorContext(ctx, "image failed to pull", "error", err, "errorClass", class, "timeout", attemptTimeout, "elapsed", elapsed)
+		noteFailure(p.metricsSink, job, source, start, elapsed, queueWait, err, class)
 		if ctx.Err() != nil {
 			logger.ErrorContext(ctx, "not retrying any more", "error", ctx.Err())
-			// Only store failure if no result exists yet (don't overwrite success from another auth).
-			results.LoadOrStore(name, false)
-			return
+			return err
+		}
+		if class.Permanent() {
+			logger.ErrorContext(ctx, "not retrying permanent failure", "errorClass", class)
+			return err
 		}
 		// Be exponentially more patient on each attempt, but prevent overflows.
-		attemptTimeout = min(attemptTimeout*2, timing.MaxPullAttemptTimeout)
-		logger.InfoContext(ctx, "sleeping before retry", "timeout", delay)
-		time.Sleep(delay)
-		delay = min(delay*2, timing.MaxPullAttemptDelay)
+		attemptTimeout = min(attemptTimeout*2, maxAttemptTimeout)
+		sleep := withJitter(delay)
+		logger.InfoContext(ctx, "sleeping before retry", "timeout", sleep)
+		if sleepErr := sleepContext(ctx, sleep); sleepErr != nil {
+			logger.ErrorContext(ctx, "not retrying any more", "error", sleepErr)
+			return err
+		}
+		delay = min(delay*2, p.timing.MaxPullAttemptDelay)
 	}
 }
 
-func getImageSize(ctx context.Context, logger *slog.Logger, client criV1.ImageServiceClient, response *criV1.PullImageResponse) uint64 {
+// withJitter returns a random duration between 50% and 150% of d, to avoid retries of many pulls happening in lockstep.
+func withJitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	return d/2 + rand.N(d)
+}
+
+// sleepContext sleeps for the given duration, or until ctx is done, in which case it returns its error.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// pullImageOnce performs a single pull attempt, once the registry limiter permits it, unless permit is not nil,
+// in which case it is the release function of a permit acquired already.
+// The returned start time and elapsed duration do not include the time spent waiting for the limiter.
+func pullImageOnce(ctx context.Context, logger *slog.Logger, client criV1.ImageServiceClient, registryLimiter *registrylimits.Limiter, permit func(), request *criV1.PullImageRequest, attemptTimeout time.Duration) (*criV1.PullImageResponse, time.Time, time.Duration, error) {
+	waitStart := time.Now()
+	if permit == nil {
+		var err error
+		if permit, err = registryLimiter.Acquire(ctx, request.GetImage().GetImage()); err != nil {
+			return nil, waitStart, time.Since(waitStart), fmt.Errorf("waiting for registry limiter: %w", err)
+		}
+	}
+	defer permit()
+	logger.Info("attempting image pull", "timeout", attemptTimeout, "registryWait", time.Since(waitStart))
+	attemptCtx, cancel := context.WithTimeout(ctx, attemptTimeout)
+	defer cancel()
+	start := time.Now()
+	response, err := client.PullImage(attemptCtx, request)
+	return response, start, time.Since(start), err
+}
+
+// verifyDigest checks that the image has the expected repo digest. An empty expected digest matches any image.
+func verifyDigest(image *criV1.Image, expectedDigest string) error {
+	if expectedDigest == "" {
+		return nil
+	}
+	if image == nil {
+		// Not a mismatch: the status call may have failed transiently, so the pull is retried.
+		return fmt.Errorf("could not obtain image status to verify expected digest %s", expectedDigest)
+	}
+	for _, repoDigest := range image.RepoDigests {
+		if _, digest, _ := strings.Cut(repoDigest, "@"); digest == expectedDigest {
+			return nil
+		}
+	}
+	return fmt.Errorf("image %s has repo digests %v rather than expected %s: %w", image.Id, image.RepoDigests, expectedDigest, pullerrors.ErrDigestMismatch)
+}
+
+// getImageStatus returns the runtime's view of the given image, or nil if it is not present or the status call fails.
+func getImageStatus(ctx context.Context, logger *slog.Logger, client criV1.ImageServiceClient, timeout time.Duration, spec *criV1.ImageSpec) *criV1.Image {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 	imageStatus, err := client.ImageStatus(ctx, &criV1.ImageStatusRequest{
-		Image: &criV1.ImageSpec{
-			Image: response.ImageRef,
-		},
+		Image: spec,
 	})
 	if err != nil {
-		logger.WarnContext(ctx, "failed to obtain pulled image status", "image", response.ImageRef, "error", err)
-		return 0
+		logger.WarnContext(ctx, "failed to obtain image status", "image", spec.Image, "error", err)
+		return nil
+	}
+	return imageStatus.GetImage()
+}
+
+func noteSuccess(sink chan<- *metricsProto.Result, job *pullJob, source credentialSource, start time.Time, elapsed time.Duration, queueWait time.Duration, sizeBytes uint64) {
+	if sink == nil {
+		return
+	}
+	sink <- &metricsProto.Result{
+		AttemptId:        uuid.NewString(),
+		StartedAt:        start.Unix(),
+		Image:            job.image,
+		RuntimeHandler:   job.runtimeHandler,
+		ResolvedDigest:   job.resolvedDigest,
+		DurationMs:       uint64(elapsed.Milliseconds()),
+		SizeBytes:        sizeBytes,
+		QueueWaitMs:      uint64(queueWait.Milliseconds()),
+		CredentialSource: string(source),
 	}
-	return imageStatus.GetImage().GetSize()
 }
 
-func noteSuccess(sink chan<- *metricsProto.Result, name string, start time.Time, elapsed time.Duration, sizeBytes uint64) {
+func noteFailure(sink chan<- *metricsProto.Result, job *pullJob, source credentialSource, start time.Time, elapsed time.Duration, queueWait time.Duration, err error, class pullerrors.Class) {
 	if sink == nil {
 		return
 	}
 	sink <- &metricsProto.Result{
-		AttemptId:  uuid.NewString(),
-		StartedAt:  start.Unix(),
-		Image:      name,
-		DurationMs: uint64(elapsed.Milliseconds()),
-		SizeBytes:  sizeBytes,
+		AttemptId:        uuid.NewString(),
+		StartedAt:        start.Unix(),
+		Image:            job.image,
+		RuntimeHandler:   job.runtimeHandler,
+		ResolvedDigest:   job.resolvedDigest,
+		DurationMs:       uint64(elapsed.Milliseconds()),
+		Error:            err.Error(),
+		ErrorClass:       string(class),
+		QueueWaitMs:      uint64(queueWait.Milliseconds()),
+		CredentialSource: string(source),
 	}
 }
 
-func noteFailure(sink chan<- *metricsProto.Result, name string, start time.Time, elapsed time.Duration, err error) {
+func noteAlreadyPresent(sink chan<- *metricsProto.Result, job *pullJob, sizeBytes uint64) {
 	if sink == nil {
 		return
 	}
 	sink <- &metricsProto.Result{
-		AttemptId:  uuid.NewString(),
-		StartedAt:  start.Unix(),
-		Image:      name,
-		DurationMs: uint64(elapsed.Milliseconds()),
-		Error:      err.Error(),
+		AttemptId:      uuid.NewString(),
+		StartedAt:      time.Now().Unix(),
+		Image:          job.image,
+		RuntimeHandler: job.runtimeHandler,
+		ResolvedDigest: job.resolvedDigest,
+		SizeBytes:      sizeBytes,
+		AlreadyPresent: true,
 	}
 }
@@ -0,0 +1,362 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stackrox/image-prefetcher/internal/credentialprovider"
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+	metricsProto "github.com/stackrox/image-prefetcher/internal/metrics/gen"
+	"github.com/stackrox/image-prefetcher/internal/nodelabels"
+	"github.com/stackrox/image-prefetcher/internal/pullerrors"
+
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	criV1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// fakeImageService returns the configured pull errors in sequence, and succeeds afterwards.
+type fakeImageService struct {
+	criV1.ImageServiceClient // unimplemented methods panic
+	mutex                    sync.Mutex
+	pullErrors               []error
+	statusErrors             []error
+	pulls                    []*criV1.PullImageRequest
+	images                   map[string]*criV1.Image
+	removals                 []string
+	imageFs                  *criV1.FilesystemUsage // ImageFsInfo is unimplemented if nil
+}
+
+func (f *fakeImageService) PullImage(ctx context.Context, in *criV1.PullImageRequest, _ ...grpc.CallOption) (*criV1.PullImageResponse, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.pulls = append(f.pulls, in)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(f.pullErrors) > 0 {
+		err := f.pullErrors[0]
+		f.pullErrors = f.pullErrors[1:]
+		return nil, err
+	}
+	return &criV1.PullImageResponse{ImageRef: in.GetImage().GetImage()}, nil
+}
+
+func (f *fakeImageService) ImageStatus(_ context.Context, in *criV1.ImageStatusRequest, _ ...grpc.CallOption) (*criV1.ImageStatusResponse, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(f.statusErrors) > 0 {
+		err := f.statusErrors[0]
+		f.statusErrors = f.statusErrors[1:]
+		return nil, err
+	}
+	return &criV1.ImageStatusResponse{Image: f.images[in.GetImage().GetImage()]}, nil
+}
+
+func (f *fakeImageService) ImageFsInfo(_ context.Context, _ *criV1.ImageFsInfoRequest, _ ...grpc.CallOption) (*criV1.ImageFsInfoResponse, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.imageFs == nil {
+		return nil, status.Error(codes.Unimplemented, "ImageFsInfo not implemented")
+	}
+	return &criV1.ImageFsInfoResponse{ImageFilesystems: []*criV1.FilesystemUsage{f.imageFs}}, nil
+}
+
+var testTiming = TimingConfig{
+	ImageListTimeout:          time.Second,
+	InitialPullAttemptTimeout: time.Second,
+	MaxPullAttemptTimeout:     time.Second,
+	OverallTimeout:            10 * time.Second,
+	InitialPullAttemptDelay:   time.Millisecond,
+	MaxPullAttemptDelay:       time.Millisecond,
+}
+
+func drainMetrics(sink chan *metricsProto.Result) <-chan []*metricsProto.Result {
+	out := make(chan []*metricsProto.Result)
+	go func() {
+		var metrics []*metricsProto.Result
+		for m := range sink {
+			metrics = append(metrics, m)
+		}
+		out <- metrics
+	}()
+	return out
+}
+
+func TestPullImage(t *testing.T) {
+	notFound := status.Error(codes.Unknown, "failed to resolve reference: not found")
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	unauthorized := status.Error(codes.Unknown, "pulling from host quay.io failed with status code: 401 UNAUTHORIZED")
+	secret := credential{source: credentialSourcePullSecret, auth: &criV1.AuthConfig{Username: "user"}}
+	anonymous := credential{source: credentialSourceAnonymous}
+	tests := map[string]struct {
+		credentials     []credential
+		pullErrors      []error
+		statusErrors    []error
+		expectedDigest  string
+		runtimeHandler  string
+		expectedPulls   int
+		expectedOutcome nodelabels.Outcome
+		expectedClasses []pullerrors.Class
+		expectedSources []credentialSource
+	}{
+		"success": {
+			expectedPulls:   1,
+			expectedOutcome: nodelabels.OutcomePulled,
+			expectedClasses: []pullerrors.Class{""},
+			expectedSources: []credentialSource{credentialSourceAnonymous},
+		},
+		"runtime handler": {
+			runtimeHandler:  "kata",
+			expectedPulls:   1,
+			expectedOutcome: nodelabels.OutcomePulled,
+			expectedClasses: []pullerrors.Class{""},
+			expectedSources: []credentialSource{credentialSourceAnonymous},
+		},
+		"transient failures are retried": {
+			pullErrors:      []error{unavailable, unavailable},
+			expectedPulls:   3,
+			expectedOutcome: nodelabels.OutcomePulled,
+			expectedClasses: []pullerrors.Class{pullerrors.ClassUnavailable, pullerrors.ClassUnavailable, ""},
+			expectedSources: []credentialSource{credentialSourceAnonymous, credentialSourceAnonymous, credentialSourceAnonymous},
+		},
+		"permanent failure ends retries": {
+			pullErrors:      []error{unavailable, notFound, unavailable},
+			expectedPulls:   2,
+			expectedOutcome: nodelabels.OutcomeFailed,
+			expectedClasses: []pullerrors.Class{pullerrors.ClassUnavailable, pullerrors.ClassNotFound},
+			expectedSources: []credentialSource{credentialSourceAnonymous, credentialSourceAnonymous},
+		},
+		"rejected credentials fall back to next ones": {
+			credentials:     []credential{secret, anonymous},
+			pullErrors:      []error{unauthorized},
+			expectedPulls:   2,
+			expectedOutcome: nodelabels.OutcomePulled,
+			expectedClasses: []pullerrors.Class{pullerrors.ClassUnauthorized, ""},
+			expectedSources: []credentialSource{credentialSourcePullSecret, credentialSourceAnonymous},
+		},
+		"other failures do not fall back": {
+			credentials:     []credential{secret, anonymous},
+			pullErrors:      []error{notFound},
+			expectedPulls:   1,
+			expectedOutcome: nodelabels.OutcomeFailed,
+			expectedClasses: []pullerrors.Class{pullerrors.ClassNotFound},
+			expectedSources: []credentialSource{credentialSourcePullSecret},
+		},
+		"expected digest matches": {
+			expectedDigest:  "sha256:aaa",
+			expectedPulls:   1,
+			expectedOutcome: nodelabels.OutcomePulled,
+			expectedClasses: []pullerrors.Class{""},
+			expectedSources: []credentialSource{credentialSourceAnonymous},
+		},
+		"expected digest mismatch": {
+			expectedDigest:  "sha256:bbb",
+			expectedPulls:   1,
+			expectedOutcome: nodelabels.OutcomeFailed,
+			expectedClasses: []pullerrors.Class{pullerrors.ClassDigestMismatch},
+			expectedSources: []credentialSource{credentialSourceAnonymous},
+		},
+		"image status unavailable after pull is retried": {
+			statusErrors:    []error{unavailable},
+			expectedDigest:  "sha256:aaa",
+			expectedPulls:   2,
+			expectedOutcome: nodelabels.OutcomePulled,
+			expectedClasses: []pullerrors.Class{pullerrors.ClassUnknown, ""},
+			expectedSources: []credentialSource{credentialSourceAnonymous, credentialSourceAnonymous},
+		},
+		"all credentials rejected": {
+			credentials:     []credential{secret, anonymous},
+			pullErrors:      []error{unauthorized, unauthorized},
+			expectedPulls:   2,
+			expectedOutcome: nodelabels.OutcomeFailed,
+			expectedClasses: []pullerrors.Class{pullerrors.ClassUnauthorized, pullerrors.ClassUnauthorized},
+			expectedSources: []credentialSource{credentialSourcePullSecret, credentialSourceAnonymous},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			client := &fakeImageService{
+				pullErrors:   test.pullErrors,
+				statusErrors: test.statusErrors,
+				images: map[string]*criV1.Image{
+					"nginx": {Id: "sha256:123", RepoDigests: []string{"docker.io/library/nginx@sha256:aaa"}},
+				},
+			}
+			sink := make(chan *metricsProto.Result)
+			metrics := drainMetrics(sink)
+			var results, failures sync.Map
+			p := &puller{client: client, metricsSink: sink, timing: testTiming, results: &results, failures: &failures}
+			target := pullTarget{image: "nginx", runtimeHandler: test.runtimeHandler}
+			credentials := test.credentials
+			if credentials == nil {
+				credentials = []credential{anonymous}
+			}
+			p.pullImage(t.Context(), &pullJob{
+				pullTarget:     target,
+				logger:         slogt.New(t),
+				expectedDigest: test.expectedDigest,
+				credentials:    credentials,
+			}, 0)
+			close(sink)
+
+			assert.Len(t, client.pulls, test.expectedPulls)
+			for _, pull := range client.pulls {
+				assert.Equal(t, test.runtimeHandler, pull.GetImage().GetRuntimeHandler())
+			}
+			outcome, _ := results.Load(target)
+			assert.Equal(t, test.expectedOutcome, outcome)
+			failure, failed := failures.Load(target)
+			if assert.Equal(t, test.expectedOutcome == nodelabels.OutcomeFailed, failed) && failed {
+				assert.Equal(t, test.expectedClasses[len(test.expectedClasses)-1], failure)
+			}
+			var classes []pullerrors.Class
+			var sources []credentialSource
+			for _, m := range <-metrics {
+				assert.Equal(t, test.runtimeHandler, m.RuntimeHandler)
+				classes = append(classes, pullerrors.Class(m.ErrorClass))
+				sources = append(sources, credentialSource(m.CredentialSource))
+			}
+			assert.Equal(t, test.expectedClasses, classes)
+			assert.Equal(t, test.expectedSources, sources)
+		})
+	}
+}
+
+func TestGetCredentialsForImage(t *testing.T) {
+	kr := &credentialprovider.BasicDockerKeyring{}
+	kr.Add(credentialprovider.DockerConfig{"quay.io": {Username: "user", Password: "pass"}})
+	logger := slogt.New(t)
+
+	creds := getCredentialsForImage(t.Context(), logger, nil, kr, "nginx", false)
+	assert.Equal(t, []credential{{source: credentialSourceAnonymous}}, creds)
+
+	creds = getCredentialsForImage(t.Context(), logger, nil, kr, "quay.io/foo/bar", false)
+	if assert.Len(t, creds, 1) {
+		assert.Equal(t, credentialSourcePullSecret, creds[0].source)
+		assert.Equal(t, "user", creds[0].auth.Username)
+	}
+
+	creds = getCredentialsForImage(t.Context(), logger, nil, kr, "quay.io/foo/bar", true)
+	if assert.Len(t, creds, 2) {
+		assert.Equal(t, credentialSourcePullSecret, creds[0].source)
+		assert.Equal(t, credential{source: credentialSourceAnonymous}, creds[1])
+	}
+}
+
+func TestRuntimeHandlers(t *testing.T) {
+	assert.Equal(t, []string{""}, runtimeHandlers(imagelist.Image{}, Config{}))
+	assert.Equal(t, []string{"kata"}, runtimeHandlers(imagelist.Image{}, Config{RuntimeHandlers: []string{"kata"}}))
+	assert.Equal(t, []string{"gvisor", "runc"}, runtimeHandlers(imagelist.Image{RuntimeHandlers: []string{"gvisor", "runc"}}, Config{RuntimeHandlers: []string{"kata"}}))
+}
+
+func TestPlatformMatches(t *testing.T) {
+	assert.True(t, platformMatches(""))
+	assert.True(t, platformMatches(runtime.GOOS+"/"+runtime.GOARCH))
+	assert.False(t, platformMatches(runtime.GOOS+"/other"))
+	assert.False(t, platformMatches("other/"+runtime.GOARCH))
+}
+
+func TestNodeSelectorFilter(t *testing.T) {
+	gpuSelector, err := imagelist.ParseNodeSelector("nvidia.com/gpu.present=true")
+	require.NoError(t, err)
+	plain := imagelist.Image{Name: "nginx"}
+	gpu := imagelist.Image{Name: "cuda", NodeSelector: gpuSelector}
+	labelsOf := func(nodeLabels map[string]string, err error) func(context.Context) (map[string]string, error) {
+		return func(context.Context) (map[string]string, error) { return nodeLabels, err }
+	}
+
+	cpuNode := nodeSelectorFilter(t.Context(), slogt.New(t), []imagelist.Image{plain, gpu}, labelsOf(map[string]string{"kubernetes.io/os": "linux"}, nil))
+	assert.True(t, cpuNode(plain))
+	assert.False(t, cpuNode(gpu))
+
+	gpuNode := nodeSelectorFilter(t.Context(), slogt.New(t), []imagelist.Image{plain, gpu}, labelsOf(map[string]string{"nvidia.com/gpu.present": "true"}, nil))
+	assert.True(t, gpuNode(plain))
+	assert.True(t, gpuNode(gpu))
+
+	unknownNode := nodeSelectorFilter(t.Context(), slogt.New(t), []imagelist.Image{plain, gpu}, labelsOf(nil, errors.New("forbidden")))
+	assert.True(t, unknownNode(gpu))
+
+	noSelectors := nodeSelectorFilter(t.Context(), slogt.New(t), []imagelist.Image{plain}, func(context.Context) (map[string]string, error) {
+		t.Fatal("node labels must not be read if no image has a node selector")
+		return nil, nil
+	})
+	assert.True(t, noSelectors(plain))
+}
+
+func TestPodSandboxConfig(t *testing.T) {
+	assert.Nil(t, SandboxConfig{}.podSandboxConfig(SandboxConfig{}, "uid"))
+
+	config := SandboxConfig{
+		Namespace:   "team-a",
+		Labels:      map[string]string{"app": "web"},
+		Annotations: map[string]string{"example.com/snapshotter": "stargz"},
+	}
+	sandbox := config.podSandboxConfig(SandboxConfig{}, "uid")
+	require.NotNil(t, sandbox)
+	assert.Equal(t, "team-a", sandbox.GetMetadata().GetNamespace())
+	assert.Equal(t, "uid", sandbox.GetMetadata().GetUid())
+	assert.Equal(t, "web", sandbox.GetLabels()["app"])
+	assert.Equal(t, "team-a", sandbox.GetLabels()["io.kubernetes.pod.namespace"])
+	assert.Equal(t, config.Annotations, sandbox.GetAnnotations())
+
+	overridden := config.podSandboxConfig(SandboxConfig{
+		Namespace:   "team-b",
+		Labels:      map[string]string{"app": "api", "tier": "backend"},
+		Annotations: map[string]string{"example.com/mirror": "local"},
+	}, "uid")
+	assert.Equal(t, "team-b", overridden.GetMetadata().GetNamespace())
+	assert.Equal(t, "team-b", overridden.GetLabels()["io.kubernetes.pod.namespace"])
+	assert.Equal(t, "api", overridden.GetLabels()["app"])
+	assert.Equal(t, "backend", overridden.GetLabels()["tier"])
+	assert.Equal(t, map[string]string{"example.com/snapshotter": "stargz", "example.com/mirror": "local"}, overridden.GetAnnotations())
+	// Overrides of one image do not leak into the configuration of others.
+	assert.Equal(t, map[string]string{"example.com/snapshotter": "stargz"}, config.Annotations)
+
+	defaulted := SandboxConfig{Labels: map[string]string{"app": "web"}}.podSandboxConfig(SandboxConfig{}, "uid")
+	assert.Equal(t, "default", defaulted.GetMetadata().GetNamespace())
+
+	imageOnly := SandboxConfig{}.podSandboxConfig(SandboxConfig{Annotations: map[string]string{"example.com/mirror": "local"}}, "uid")
+	require.NotNil(t, imageOnly)
+	assert.Equal(t, "default", imageOnly.GetMetadata().GetNamespace())
+	assert.Equal(t, map[string]string{"example.com/mirror": "local"}, imageOnly.GetAnnotations())
+}
+
+func TestVerifyDigest(t *testing.T) {
+	image := &criV1.Image{Id: "sha256:123", RepoDigests: []string{"quay.io/a/b@sha256:aaa", "quay.io/c/d@sha256:bbb"}}
+	assert.NoError(t, verifyDigest(image, ""))
+	assert.NoError(t, verifyDigest(nil, ""))
+	assert.NoError(t, verifyDigest(image, "sha256:aaa"))
+	assert.NoError(t, verifyDigest(image, "sha256:bbb"))
+	assert.ErrorIs(t, verifyDigest(image, "sha256:ccc"), pullerrors.ErrDigestMismatch)
+	err := verifyDigest(nil, "sha256:aaa")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, pullerrors.ErrDigestMismatch)
+	assert.False(t, pullerrors.Classify(err).Permanent())
+}
+
+func TestSleepContext(t *testing.T) {
+	assert.NoError(t, sleepContext(t.Context(), time.Millisecond))
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	start := time.Now()
+	assert.ErrorIs(t, sleepContext(ctx, time.Hour), context.Canceled)
+	assert.Less(t, time.Since(start), time.Minute)
+}
+
+func TestWithJitter(t *testing.T) {
+	assert.Equal(t, time.Duration(0), withJitter(0))
+	for range 100 {
+		d := withJitter(time.Second)
+		assert.GreaterOrEqual(t, d, 500*time.Millisecond)
+		assert.Less(t, d, 1500*time.Millisecond)
+	}
+}
@@ -19,7 +19,10 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	Metrics_Submit_FullMethodName = "/Metrics/Submit"
+	Metrics_Submit_FullMethodName                = "/Metrics/Submit"
+	Metrics_GetImageSizes_FullMethodName         = "/Metrics/GetImageSizes"
+	Metrics_ResolveTags_FullMethodName           = "/Metrics/ResolveTags"
+	Metrics_ReportFilesystemUsage_FullMethodName = "/Metrics/ReportFilesystemUsage"
 )
 
 // MetricsClient is the client API for Metrics service.
@@ -27,6 +30,9 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MetricsClient interface {
 	Submit(ctx context.Context, opts ...grpc.CallOption) (Metrics_SubmitClient, error)
+	GetImageSizes(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ImageSizes, error)
+	ResolveTags(ctx context.Context, in *TagResolutionRequest, opts ...grpc.CallOption) (*ResolvedDigests, error)
+	ReportFilesystemUsage(ctx context.Context, in *FilesystemUsage, opts ...grpc.CallOption) (*Empty, error)
 }
 
 type metricsClient struct {
@@ -71,11 +77,41 @@ func (x *metricsSubmitClient) CloseAndRecv() (*Empty, error) {
 	return m, nil
 }
 
+func (c *metricsClient) GetImageSizes(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ImageSizes, error) {
+	out := new(ImageSizes)
+	err := c.cc.Invoke(ctx, Metrics_GetImageSizes_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) ResolveTags(ctx context.Context, in *TagResolutionRequest, opts ...grpc.CallOption) (*ResolvedDigests, error) {
+	out := new(ResolvedDigests)
+	err := c.cc.Invoke(ctx, Metrics_ResolveTags_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) ReportFilesystemUsage(ctx context.Context, in *FilesystemUsage, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Metrics_ReportFilesystemUsage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MetricsServer is the server API for Metrics service.
 // All implementations must embed UnimplementedMetricsServer
 // for forward compatibility
 type MetricsServer interface {
 	Submit(Metrics_SubmitServer) error
+	GetImageSizes(context.Context, *Empty) (*ImageSizes, error)
+	ResolveTags(context.Context, *TagResolutionRequest) (*ResolvedDigests, error)
+	ReportFilesystemUsage(context.Context, *FilesystemUsage) (*Empty, error)
 	mustEmbedUnimplementedMetricsServer()
 }
 
@@ -86,6 +122,15 @@ type UnimplementedMetricsServer struct {
 func (UnimplementedMetricsServer) Submit(Metrics_SubmitServer) error {
 	return status.Errorf(codes.Unimplemented, "method Submit not implemented")
 }
+func (UnimplementedMetricsServer) GetImageSizes(context.Context, *Empty) (*ImageSizes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetImageSizes not implemented")
+}
+func (UnimplementedMetricsServer) ResolveTags(context.Context, *TagResolutionRequest) (*ResolvedDigests, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResolveTags not implemented")
+}
+func (UnimplementedMetricsServer) ReportFilesystemUsage(context.Context, *FilesystemUsage) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportFilesystemUsage not implemented")
+}
 func (UnimplementedMetricsServer) mustEmbedUnimplementedMetricsServer() {}
 
 // UnsafeMetricsServer may be embedded to opt out of forward compatibility for this service.
@@ -125,13 +170,80 @@ func (x *metricsSubmitServer) Recv() (*Result, error) {
 	return m, nil
 }
 
+func _Metrics_GetImageSizes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).GetImageSizes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_GetImageSizes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).GetImageSizes(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_ResolveTags_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TagResolutionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).ResolveTags(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_ResolveTags_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).ResolveTags(ctx, req.(*TagResolutionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_ReportFilesystemUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FilesystemUsage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).ReportFilesystemUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_ReportFilesystemUsage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).ReportFilesystemUsage(ctx, req.(*FilesystemUsage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Metrics_ServiceDesc is the grpc.ServiceDesc for Metrics service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
 var Metrics_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "Metrics",
 	HandlerType: (*MetricsServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetImageSizes",
+			Handler:    _Metrics_GetImageSizes_Handler,
+		},
+		{
+			MethodName: "ResolveTags",
+			Handler:    _Metrics_ResolveTags_Handler,
+		},
+		{
+			MethodName: "ReportFilesystemUsage",
+			Handler:    _Metrics_ReportFilesystemUsage_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "Submit",
@@ -1,24 +1,33 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net"
 	"net/http"
+	"slices"
 	"sync"
 
 	"github.com/stackrox/image-prefetcher/internal/metrics/gen"
+	"github.com/stackrox/image-prefetcher/internal/tagresolver"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type metricsServer struct {
 	mutex   sync.Mutex
 	metrics map[string]*gen.Result
-	logger  *slog.Logger
+	// filesystems holds the latest image filesystem usage reported for each node and stage.
+	filesystems map[string]*gen.FilesystemUsage
+	logger      *slog.Logger
+	resolver    *tagresolver.Resolver // nil if tag resolution is disabled
 	gen.UnimplementedMetricsServer
 }
 
@@ -45,6 +54,63 @@ func (s *metricsServer) metricSubmitted(metric *gen.Result) {
 	s.metrics[metric.AttemptId] = metric
 }
 
+// GetImageSizes returns the largest size reported for each image by successful pulls, so that clients can order
+// their pulls by size. Different sizes for the same name are possible, e.g. for mutable tags or different platforms.
+func (s *metricsServer) GetImageSizes(_ context.Context, _ *gen.Empty) (*gen.ImageSizes, error) {
+	sizes := make(map[string]uint64)
+	for _, metric := range s.currentMetrics() {
+		if metric.Error != "" || metric.SizeBytes == 0 {
+			continue
+		}
+		sizes[metric.Image] = max(sizes[metric.Image], metric.SizeBytes)
+	}
+	return &gen.ImageSizes{SizeBytes: sizes}, nil
+}
+
+// ResolveTags resolves image tags to digests, which the resolver caches for a while, so that all clients of a rollout
+// pull the same digests. Images which cannot be resolved, or are not cached if only cached digests are requested,
+// are left out of the response.
+func (s *metricsServer) ResolveTags(ctx context.Context, request *gen.TagResolutionRequest) (*gen.ResolvedDigests, error) {
+	if s.resolver == nil {
+		return nil, status.Error(codes.FailedPrecondition, "tag resolution is not enabled")
+	}
+	var mutex sync.Mutex
+	digests := make(map[string]string, len(request.GetImages()))
+	var wg sync.WaitGroup
+	if request.GetCachedOnly() {
+		for _, image := range request.GetImages() {
+			if digest, ok := s.resolver.Cached(image); ok {
+				digests[image] = digest
+			}
+		}
+		return &gen.ResolvedDigests{Digests: digests}, nil
+	}
+	for _, image := range request.GetImages() {
+		wg.Go(func() {
+			digest, err := s.resolver.Resolve(ctx, image)
+			if err != nil {
+				s.logger.WarnContext(ctx, "failed to resolve image tag", "image", image, "error", err)
+				return
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			digests[image] = digest
+		})
+	}
+	wg.Wait()
+	return &gen.ResolvedDigests{Digests: digests}, nil
+}
+
+// ReportFilesystemUsage records the usage of the image filesystem of a node, replacing earlier reports
+// of the node for the same stage.
+func (s *metricsServer) ReportFilesystemUsage(_ context.Context, usage *gen.FilesystemUsage) (*gen.Empty, error) {
+	s.logger.Debug("filesystem usage reported", "usage", usage)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.filesystems[usage.Node+"/"+usage.Stage] = usage
+	return &gen.Empty{}, nil
+}
+
 func (s *metricsServer) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
 	resp, err := json.Marshal(s.currentMetrics())
 	if err != nil {
@@ -58,6 +124,21 @@ func (s *metricsServer) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
 	}
 }
 
+func (s *metricsServer) serveFilesystems(writer http.ResponseWriter, _ *http.Request) {
+	s.mutex.Lock()
+	filesystems := slices.Collect(maps.Values(s.filesystems))
+	s.mutex.Unlock()
+	resp, err := json.Marshal(filesystems)
+	if err != nil {
+		s.logger.Error("failed to marshal filesystem usage", "error", err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err = writer.Write(resp); err != nil {
+		s.logger.Error("failed to write HTTP filesystem usage response", "error", err)
+	}
+}
+
 func (s *metricsServer) currentMetrics() []*gen.Result {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -68,10 +149,13 @@ func (s *metricsServer) currentMetrics() []*gen.Result {
 	return metrics
 }
 
-func Run(logger *slog.Logger, grpcPort int, httpPort int) error {
+// Run serves metrics until either server fails. Tag resolution is served if resolver is not nil.
+func Run(logger *slog.Logger, grpcPort int, httpPort int, resolver *tagresolver.Resolver) error {
 	server := &metricsServer{
-		logger:  logger,
-		metrics: make(map[string]*gen.Result),
+		logger:      logger,
+		metrics:     make(map[string]*gen.Result),
+		filesystems: make(map[string]*gen.FilesystemUsage),
+		resolver:    resolver,
 	}
 	grpcErrChan := make(chan error)
 	httpErrChan := make(chan error)
@@ -93,6 +177,7 @@ func Run(logger *slog.Logger, grpcPort int, httpPort int) error {
 	}
 	httpServer := &http.Server{}
 	http.Handle("/metrics", server)
+	http.HandleFunc("/filesystems", server.serveFilesystems)
 	logger.Info("starting to serve", "httpSpec", httpSpec)
 	go func() { httpErrChan <- httpServer.Serve(httpListener) }()
 
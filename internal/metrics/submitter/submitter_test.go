@@ -27,6 +27,18 @@ func (f *fakeClient) Submit(ctx context.Context, _ ...grpc.CallOption) (gen.Metr
 	return &fakeSubmitClient{}, nil
 }
 
+func (f *fakeClient) GetImageSizes(_ context.Context, _ *gen.Empty, _ ...grpc.CallOption) (*gen.ImageSizes, error) {
+	return nil, nil
+}
+
+func (f *fakeClient) ResolveTags(_ context.Context, _ *gen.TagResolutionRequest, _ ...grpc.CallOption) (*gen.ResolvedDigests, error) {
+	return nil, nil
+}
+
+func (f *fakeClient) ReportFilesystemUsage(_ context.Context, _ *gen.FilesystemUsage, _ ...grpc.CallOption) (*gen.Empty, error) {
+	return nil, nil
+}
+
 type fakeSubmitClient struct {
 }
 
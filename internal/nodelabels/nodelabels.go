@@ -3,19 +3,21 @@ package nodelabels
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/stackrox/image-prefetcher/internal/kube"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/validation"
-	"k8s.io/client-go/kubernetes"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
-	"k8s.io/client-go/rest"
 	"k8s.io/client-go/util/retry"
 )
 
@@ -28,20 +30,50 @@ const (
 
 	// LabelValueFailed indicates one or more images failed to prefetch.
 	LabelValueFailed = "failed"
+
+	// LabelValuePartial indicates prefetching was interrupted before all images were pulled, with no failures so far.
+	LabelValuePartial = "partial"
+
+	// LabelValueMissing indicates images prefetched earlier were since removed from the node, for example
+	// by kubelet image garbage collection, and are not pulled again yet. There were no failures so far.
+	LabelValueMissing = "missing"
+
+	// AlreadyPresentLabelPrefix is the prefix for labels counting images which were skipped
+	// because they were already present on the node.
+	AlreadyPresentLabelPrefix = "already-present." + LabelPrefix
 )
 
+// Outcome is the result of prefetching a single image.
+type Outcome string
+
+const (
+	// OutcomePulled means the image was pulled successfully.
+	OutcomePulled Outcome = "pulled"
+	// OutcomeAlreadyPresent means the pull was skipped because the image was already present.
+	OutcomeAlreadyPresent Outcome = "already-present"
+	// OutcomeFailed means the image could not be pulled.
+	OutcomeFailed Outcome = "failed"
+	// OutcomeInterrupted means the pull was cancelled because the prefetcher was terminated.
+	OutcomeInterrupted Outcome = "interrupted"
+	// OutcomeMissing means the image was found missing after it was prefetched, and is about to be pulled again.
+	OutcomeMissing Outcome = "missing"
+)
+
+// Status is the prefetch status of an instance on a node, as recorded in node labels by an earlier run.
+// The zero value means nothing was recorded.
+type Status struct {
+	// Value is the value of the status label, such as LabelValueSuccess.
+	Value string
+	// AlreadyPresent is the number of images which were already present.
+	AlreadyPresent int
+}
+
 // NewClient creates a new Kubernetes node client using in-cluster configuration.
 func NewClient() (corev1.NodeInterface, error) {
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get in-cluster config: %w", err)
-	}
-
-	clientset, err := kubernetes.NewForConfig(config)
+	clientset, err := kube.NewClientset()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+		return nil, err
 	}
-
 	return clientset.CoreV1().Nodes(), nil
 }
 
@@ -49,6 +81,12 @@ func NewClient() (corev1.NodeInterface, error) {
 // This function combines client initialization and label updates in a single operation.
 // If environment variables are not set or client creation fails, it logs a warning and returns without error.
 func PatchNodeLabels(ctx context.Context, results *sync.Map, logger *slog.Logger) error {
+	return PatchNodeLabelsSince(ctx, Status{}, results, logger)
+}
+
+// PatchNodeLabelsSince is like PatchNodeLabels, but combines results with the status recorded earlier,
+// as returned by GetStatus. The combined status is failed if either of them is.
+func PatchNodeLabelsSince(ctx context.Context, earlier Status, results *sync.Map, logger *slog.Logger) error {
 	nodeName := os.Getenv("NODE_NAME")
 	instanceName := os.Getenv("INSTANCE_NAME")
 
@@ -70,7 +108,7 @@ func PatchNodeLabels(ctx context.Context, results *sync.Map, logger *slog.Logger
 	logger.Info("Kubernetes client initialized for node labeling", "node", nodeName, "instance", instanceName)
 
 	// Generate labels based on prefetch results
-	labels := generatePrefetchStatusLabels(instanceName, results)
+	labels := generatePrefetchStatusLabels(instanceName, earlier, results)
 
 	if err := patchNodeLabelsWithClient(ctx, nodeClient, nodeName, labels, logger); err != nil {
 		return fmt.Errorf("failed to update node labels: %w", err)
@@ -79,6 +117,51 @@ func PatchNodeLabels(ctx context.Context, results *sync.Map, logger *slog.Logger
 	return nil
 }
 
+// GetNodeLabels returns the labels of the node named by the NODE_NAME environment variable.
+func GetNodeLabels(ctx context.Context) (map[string]string, error) {
+	nodeName := os.Getenv("NODE_NAME")
+	if nodeName == "" {
+		return nil, errors.New("NODE_NAME environment variable not set")
+	}
+	nodeClient, err := NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	return getNodeLabelsWithClient(ctx, nodeClient, nodeName)
+}
+
+// GetStatus returns the prefetch status recorded in labels of the node named by the NODE_NAME environment variable,
+// for the instance named by the INSTANCE_NAME environment variable.
+func GetStatus(ctx context.Context) (Status, error) {
+	instanceName := os.Getenv("INSTANCE_NAME")
+	if instanceName == "" {
+		return Status{}, errors.New("INSTANCE_NAME environment variable not set")
+	}
+	labels, err := GetNodeLabels(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	return statusFromLabels(instanceName, labels), nil
+}
+
+func statusFromLabels(instanceName string, labels map[string]string) Status {
+	sanitizedInstanceName := sanitizeLabelName(instanceName)
+	// A malformed count is as good as none.
+	alreadyPresent, _ := strconv.Atoi(labels[AlreadyPresentLabelPrefix+sanitizedInstanceName])
+	return Status{
+		Value:          labels[LabelPrefix+sanitizedInstanceName],
+		AlreadyPresent: alreadyPresent,
+	}
+}
+
+func getNodeLabelsWithClient(ctx context.Context, nodeClient corev1.NodeInterface, nodeName string) (map[string]string, error) {
+	node, err := nodeClient.Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node %s: %w", nodeName, err)
+	}
+	return node.Labels, nil
+}
+
 // sanitizeLabelName converts an arbitrary string into a valid Kubernetes label name.
 // Label names must:
 // - Be at most 63 characters.
@@ -101,23 +184,47 @@ func sanitizeLabelName(s string) string {
 	return sanitized
 }
 
-// generatePrefetchStatusLabels creates a map of labels based on prefetch results.
-// This is a pure function that determines the label key and value without side effects.
-func generatePrefetchStatusLabels(instanceName string, results *sync.Map) map[string]string {
-	// Determine overall status: success if ALL images succeeded, failed otherwise.
+// labelPrecedence orders label values from the best to the worst, so that the worst one wins. Unknown values rank as success.
+var labelPrecedence = map[string]int{
+	LabelValueSuccess: 0,
+	LabelValuePartial: 1,
+	LabelValueMissing: 2,
+	LabelValueFailed:  3,
+}
+
+// generatePrefetchStatusLabels creates a map of labels based on prefetch results, combined with the earlier status.
+// This is a pure function that determines the label keys and values without side effects.
+func generatePrefetchStatusLabels(instanceName string, earlier Status, results *sync.Map) map[string]string {
+	// Determine overall status: success if ALL images are available, failed if any failed,
+	// otherwise missing if some went missing, and partial if some were interrupted.
 	labelValue := LabelValueSuccess
+	worse := func(value string) {
+		if labelPrecedence[value] > labelPrecedence[labelValue] {
+			labelValue = value
+		}
+	}
+	worse(earlier.Value)
+	alreadyPresent := earlier.AlreadyPresent
 	results.Range(func(key, value interface{}) bool {
-		if !value.(bool) {
-			labelValue = LabelValueFailed
-			return false
+		switch value.(Outcome) {
+		case OutcomeFailed:
+			worse(LabelValueFailed)
+		case OutcomeMissing:
+			worse(LabelValueMissing)
+		case OutcomeInterrupted:
+			worse(LabelValuePartial)
+		case OutcomeAlreadyPresent:
+			alreadyPresent++
 		}
 		return true
 	})
 
 	sanitizedInstanceName := sanitizeLabelName(instanceName)
-	labelKey := LabelPrefix + sanitizedInstanceName
 
-	return map[string]string{labelKey: labelValue}
+	return map[string]string{
+		LabelPrefix + sanitizedInstanceName:               labelValue,
+		AlreadyPresentLabelPrefix + sanitizedInstanceName: strconv.Itoa(alreadyPresent),
+	}
 }
 
 func retryAll(err error) bool {
@@ -68,7 +68,7 @@ func TestSanitizeLabelName(t *testing.T) {
 	}
 }
 
-func makeSyncMap(m map[string]bool) *sync.Map {
+func makeSyncMap(m map[string]Outcome) *sync.Map {
 	var sm sync.Map
 	for k, v := range m {
 		sm.Store(k, v)
@@ -80,31 +80,69 @@ func TestUpdateNodeLabels(t *testing.T) {
 	tests := map[string]struct {
 		instanceName   string
 		existingLabels map[string]string
-		results        map[string]bool
+		earlier        Status
+		results        map[string]Outcome
 		expectedLabel  string
+		expectedCount  string
 		nodeMissing    bool
 	}{
 		"all images succeeded": {
 			instanceName: "my-images",
-			results: map[string]bool{
-				"image1": true,
-				"image2": true,
-				"image3": true,
+			results: map[string]Outcome{
+				"image1": OutcomePulled,
+				"image2": OutcomePulled,
+				"image3": OutcomePulled,
 			},
 			expectedLabel: LabelValueSuccess,
 		},
 		"some images failed": {
 			instanceName: "my-images",
-			results: map[string]bool{
-				"image1": true,
-				"image2": false,
-				"image3": true,
+			results: map[string]Outcome{
+				"image1": OutcomePulled,
+				"image2": OutcomeFailed,
+				"image3": OutcomePulled,
+			},
+			expectedLabel: LabelValueFailed,
+		},
+		"some images already present": {
+			instanceName: "my-images",
+			results: map[string]Outcome{
+				"image1": OutcomeAlreadyPresent,
+				"image2": OutcomePulled,
+				"image3": OutcomeAlreadyPresent,
+			},
+			expectedLabel: LabelValueSuccess,
+			expectedCount: "2",
+		},
+		"already present and failed": {
+			instanceName: "my-images",
+			results: map[string]Outcome{
+				"image1": OutcomeAlreadyPresent,
+				"image2": OutcomeFailed,
+			},
+			expectedLabel: LabelValueFailed,
+			expectedCount: "1",
+		},
+		"some images interrupted": {
+			instanceName: "my-images",
+			results: map[string]Outcome{
+				"image1": OutcomePulled,
+				"image2": OutcomeInterrupted,
+			},
+			expectedLabel: LabelValuePartial,
+		},
+		"interrupted and failed": {
+			instanceName: "my-images",
+			results: map[string]Outcome{
+				"image1": OutcomeInterrupted,
+				"image2": OutcomeFailed,
+				"image3": OutcomeInterrupted,
 			},
 			expectedLabel: LabelValueFailed,
 		},
 		"empty results shows success": {
 			instanceName:  "my-images",
-			results:       map[string]bool{},
+			results:       map[string]Outcome{},
 			expectedLabel: LabelValueSuccess,
 		},
 		"updates existing label": {
@@ -112,8 +150,8 @@ func TestUpdateNodeLabels(t *testing.T) {
 			existingLabels: map[string]string{
 				"image-prefetcher.stackrox.io/my-images": LabelValueFailed,
 			},
-			results: map[string]bool{
-				"image1": true,
+			results: map[string]Outcome{
+				"image1": OutcomePulled,
 			},
 			expectedLabel: LabelValueSuccess,
 		},
@@ -123,22 +161,64 @@ func TestUpdateNodeLabels(t *testing.T) {
 				"kubernetes.io/hostname": "my-images",
 				"other-label":            "value",
 			},
-			results: map[string]bool{
-				"image1": true,
+			results: map[string]Outcome{
+				"image1": OutcomePulled,
 			},
 			expectedLabel: LabelValueSuccess,
 		},
 		"sanitizes instance name": {
 			instanceName: "my images!",
-			results: map[string]bool{
-				"image1": true,
+			results: map[string]Outcome{
+				"image1": OutcomePulled,
 			},
 			expectedLabel: LabelValueSuccess,
 		},
+		"earlier failure is kept": {
+			instanceName: "my-images",
+			earlier:      Status{Value: LabelValueFailed, AlreadyPresent: 2},
+			results: map[string]Outcome{
+				"image1": OutcomePulled,
+				"image2": OutcomeAlreadyPresent,
+			},
+			expectedLabel: LabelValueFailed,
+			expectedCount: "3",
+		},
+		"earlier success combined with failure": {
+			instanceName: "my-images",
+			earlier:      Status{Value: LabelValueSuccess},
+			results: map[string]Outcome{
+				"image1": OutcomeFailed,
+			},
+			expectedLabel: LabelValueFailed,
+		},
+		"earlier interruption combined with success": {
+			instanceName: "my-images",
+			earlier:      Status{Value: LabelValuePartial},
+			results: map[string]Outcome{
+				"image1": OutcomePulled,
+			},
+			expectedLabel: LabelValuePartial,
+		},
+		"some images missing": {
+			instanceName: "my-images",
+			results: map[string]Outcome{
+				"image1": OutcomeMissing,
+				"image2": OutcomeInterrupted,
+			},
+			expectedLabel: LabelValueMissing,
+		},
+		"missing and failed": {
+			instanceName: "my-images",
+			earlier:      Status{Value: LabelValueFailed},
+			results: map[string]Outcome{
+				"image1": OutcomeMissing,
+			},
+			expectedLabel: LabelValueFailed,
+		},
 		"node not found returns error": {
 			instanceName: "my-images",
-			results: map[string]bool{
-				"image1": true,
+			results: map[string]Outcome{
+				"image1": OutcomePulled,
 			},
 			nodeMissing: true,
 		},
@@ -164,7 +244,7 @@ func TestUpdateNodeLabels(t *testing.T) {
 			ctx := context.Background()
 
 			nodeClient := fakeClient.CoreV1().Nodes()
-			labels := generatePrefetchStatusLabels(tt.instanceName, results)
+			labels := generatePrefetchStatusLabels(tt.instanceName, tt.earlier, results)
 			err := patchNodeLabelsWithClient(ctx, nodeClient, name, labels, logger)
 
 			if tt.nodeMissing {
@@ -180,6 +260,11 @@ func TestUpdateNodeLabels(t *testing.T) {
 			expectedLabelKey := LabelPrefix + sanitizedInstanceName
 
 			assert.Equal(t, tt.expectedLabel, node.Labels[expectedLabelKey])
+			expectedCount := tt.expectedCount
+			if expectedCount == "" {
+				expectedCount = "0"
+			}
+			assert.Equal(t, expectedCount, node.Labels[AlreadyPresentLabelPrefix+sanitizedInstanceName])
 
 			for k, v := range tt.existingLabels {
 				if k != expectedLabelKey {
@@ -189,3 +274,49 @@ func TestUpdateNodeLabels(t *testing.T) {
 		})
 	}
 }
+
+func TestStatusFromLabels(t *testing.T) {
+	tests := map[string]struct {
+		labels   map[string]string
+		expected Status
+	}{
+		"no labels": {},
+		"recorded status": {
+			labels: map[string]string{
+				"image-prefetcher.stackrox.io/my-images":                 LabelValueFailed,
+				"already-present.image-prefetcher.stackrox.io/my-images": "3",
+				"image-prefetcher.stackrox.io/other-images":              LabelValueSuccess,
+			},
+			expected: Status{Value: LabelValueFailed, AlreadyPresent: 3},
+		},
+		"malformed count": {
+			labels: map[string]string{
+				"image-prefetcher.stackrox.io/my-images":                 LabelValueSuccess,
+				"already-present.image-prefetcher.stackrox.io/my-images": "many",
+			},
+			expected: Status{Value: LabelValueSuccess},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, statusFromLabels("my-images", tt.labels))
+		})
+	}
+}
+
+func TestGetNodeLabels(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "gpu-node",
+			Labels: map[string]string{"nvidia.com/gpu.present": "true"},
+		},
+	}
+	nodeClient := fake.NewClientset(node).CoreV1().Nodes()
+
+	labels, err := getNodeLabelsWithClient(context.Background(), nodeClient, "gpu-node")
+	require.NoError(t, err)
+	assert.Equal(t, node.Labels, labels)
+
+	_, err = getNodeLabelsWithClient(context.Background(), nodeClient, "other-node")
+	assert.Error(t, err)
+}
@@ -0,0 +1,123 @@
+package nodelabels
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// PulledImagesAnnotationPrefix is the prefix for annotations recording images pulled by an instance,
+// so that they can be pruned once they are no longer listed.
+const PulledImagesAnnotationPrefix = "pulled." + LabelPrefix
+
+// PulledImage is an image pulled for a runtime handler.
+type PulledImage struct {
+	Image          string `json:"image"`
+	RuntimeHandler string `json:"runtimeHandler,omitempty"`
+}
+
+// GetPulledImages returns images recorded as pulled by the instance named by the INSTANCE_NAME environment variable,
+// on the node named by the NODE_NAME environment variable.
+func GetPulledImages(ctx context.Context) ([]PulledImage, error) {
+	nodeName := os.Getenv("NODE_NAME")
+	instanceName := os.Getenv("INSTANCE_NAME")
+	if nodeName == "" || instanceName == "" {
+		return nil, errors.New("NODE_NAME and INSTANCE_NAME environment variables must be set")
+	}
+	nodeClient, err := NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	node, err := nodeClient.Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node %s: %w", nodeName, err)
+	}
+	return parsePulledImages(node.Annotations[pulledImagesAnnotation(instanceName)])
+}
+
+// RecordPulledImages adds images to, and removes images from, the record of images pulled by the instance.
+// If environment variables are not set or client creation fails, it logs a warning and returns without error.
+func RecordPulledImages(ctx context.Context, logger *slog.Logger, add []PulledImage, remove []PulledImage) error {
+	if len(add) == 0 && len(remove) == 0 {
+		return nil
+	}
+	nodeName := os.Getenv("NODE_NAME")
+	instanceName := os.Getenv("INSTANCE_NAME")
+	if nodeName == "" || instanceName == "" {
+		logger.Info("NODE_NAME or INSTANCE_NAME environment variable not set, not recording pulled images")
+		return nil
+	}
+	nodeClient, err := NewClient()
+	if err != nil {
+		logger.Warn("failed to create Kubernetes client, not recording pulled images", "error", err)
+		return nil
+	}
+	return recordPulledImagesWithClient(ctx, nodeClient, nodeName, instanceName, add, remove)
+}
+
+func recordPulledImagesWithClient(ctx context.Context, nodeClient corev1.NodeInterface, nodeName, instanceName string, add []PulledImage, remove []PulledImage) error {
+	err := updateNodeAnnotation(ctx, nodeClient, nodeName, pulledImagesAnnotation(instanceName), func(value string) (string, error) {
+		// A malformed record is replaced rather than left to block recording forever.
+		pulled, _ := parsePulledImages(value)
+		for _, image := range add {
+			if !slices.Contains(pulled, image) {
+				pulled = append(pulled, image)
+			}
+		}
+		pulled = slices.DeleteFunc(pulled, func(image PulledImage) bool { return slices.Contains(remove, image) })
+		slices.SortFunc(pulled, func(a, b PulledImage) int {
+			return cmp.Or(cmp.Compare(a.Image, b.Image), cmp.Compare(a.RuntimeHandler, b.RuntimeHandler))
+		})
+		updated, err := json.Marshal(pulled)
+		return string(updated), err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record pulled images on node %s: %w", nodeName, err)
+	}
+	return nil
+}
+
+// updateNodeAnnotation sets an annotation of the node to the result of update applied to its current value.
+// Several containers of an instance may update the same annotation at the same time, so update rather than patch.
+func updateNodeAnnotation(ctx context.Context, nodeClient corev1.NodeInterface, nodeName, key string, update func(string) (string, error)) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		node, err := nodeClient.Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		value, err := update(node.Annotations[key])
+		if err != nil {
+			return err
+		}
+		if node.Annotations == nil {
+			node.Annotations = map[string]string{}
+		}
+		node.Annotations[key] = value
+		_, err = nodeClient.Update(ctx, node, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func pulledImagesAnnotation(instanceName string) string {
+	return PulledImagesAnnotationPrefix + sanitizeLabelName(instanceName)
+}
+
+func parsePulledImages(value string) ([]PulledImage, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var pulled []PulledImage
+	if err := json.Unmarshal([]byte(value), &pulled); err != nil {
+		return nil, fmt.Errorf("malformed record of pulled images: %w", err)
+	}
+	return pulled, nil
+}
@@ -0,0 +1,57 @@
+package nodelabels
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRecordPulledImages(t *testing.T) {
+	key := "pulled.image-prefetcher.stackrox.io/my-images"
+	tests := map[string]struct {
+		existing string
+		add      []PulledImage
+		remove   []PulledImage
+		expected string
+	}{
+		"first record": {
+			add:      []PulledImage{{Image: "b"}, {Image: "a", RuntimeHandler: "kata"}},
+			expected: `[{"image":"a","runtimeHandler":"kata"},{"image":"b"}]`,
+		},
+		"added to existing record without duplicates": {
+			existing: `[{"image":"a"},{"image":"c"}]`,
+			add:      []PulledImage{{Image: "b"}, {Image: "a"}, {Image: "a", RuntimeHandler: "kata"}},
+			expected: `[{"image":"a"},{"image":"a","runtimeHandler":"kata"},{"image":"b"},{"image":"c"}]`,
+		},
+		"removed": {
+			existing: `[{"image":"a"},{"image":"a","runtimeHandler":"kata"},{"image":"b"}]`,
+			remove:   []PulledImage{{Image: "a"}, {Image: "unknown"}},
+			expected: `[{"image":"a","runtimeHandler":"kata"},{"image":"b"}]`,
+		},
+		"malformed record replaced": {
+			existing: `not json`,
+			add:      []PulledImage{{Image: "a"}},
+			expected: `[{"image":"a"}]`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node", Annotations: map[string]string{"other": "kept"}}}
+			if tt.existing != "" {
+				node.Annotations[key] = tt.existing
+			}
+			nodeClient := fake.NewClientset(node).CoreV1().Nodes()
+
+			require.NoError(t, recordPulledImagesWithClient(t.Context(), nodeClient, "node", "my-images", tt.add, tt.remove))
+
+			node, err := nodeClient.Get(t.Context(), "node", metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.expected, node.Annotations[key])
+			assert.Equal(t, "kept", node.Annotations["other"])
+		})
+	}
+}
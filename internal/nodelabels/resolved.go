@@ -0,0 +1,94 @@
+package nodelabels
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// ResolvedDigestsAnnotationPrefix is the prefix for annotations recording the digests which image tags were pinned to
+// by an instance, so that it is visible which digest of a moving tag each node pulled.
+const ResolvedDigestsAnnotationPrefix = "resolved." + LabelPrefix
+
+// GetResolvedDigests returns the digests which the instance named by the INSTANCE_NAME environment variable pinned
+// image tags to, by image name, on the node named by the NODE_NAME environment variable.
+func GetResolvedDigests(ctx context.Context) (map[string]string, error) {
+	nodeName := os.Getenv("NODE_NAME")
+	instanceName := os.Getenv("INSTANCE_NAME")
+	if nodeName == "" || instanceName == "" {
+		return nil, errors.New("NODE_NAME and INSTANCE_NAME environment variables must be set")
+	}
+	nodeClient, err := NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	return getResolvedDigestsWithClient(ctx, nodeClient, nodeName, instanceName)
+}
+
+func getResolvedDigestsWithClient(ctx context.Context, nodeClient corev1.NodeInterface, nodeName, instanceName string) (map[string]string, error) {
+	node, err := nodeClient.Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node %s: %w", nodeName, err)
+	}
+	return parseResolvedDigests(node.Annotations[resolvedDigestsAnnotation(instanceName)])
+}
+
+// RecordResolvedDigests merges the given digests, by image name, into the record of digests which the instance
+// pinned image tags to. If environment variables are not set or client creation fails,
+// it logs a warning and returns without error.
+func RecordResolvedDigests(ctx context.Context, logger *slog.Logger, digests map[string]string) error {
+	if len(digests) == 0 {
+		return nil
+	}
+	nodeName := os.Getenv("NODE_NAME")
+	instanceName := os.Getenv("INSTANCE_NAME")
+	if nodeName == "" || instanceName == "" {
+		logger.Info("NODE_NAME or INSTANCE_NAME environment variable not set, not recording resolved digests")
+		return nil
+	}
+	nodeClient, err := NewClient()
+	if err != nil {
+		logger.Warn("failed to create Kubernetes client, not recording resolved digests", "error", err)
+		return nil
+	}
+	return recordResolvedDigestsWithClient(ctx, nodeClient, nodeName, instanceName, digests)
+}
+
+func recordResolvedDigestsWithClient(ctx context.Context, nodeClient corev1.NodeInterface, nodeName, instanceName string, digests map[string]string) error {
+	err := updateNodeAnnotation(ctx, nodeClient, nodeName, resolvedDigestsAnnotation(instanceName), func(value string) (string, error) {
+		// A malformed record is replaced rather than left to block recording forever.
+		resolved, err := parseResolvedDigests(value)
+		if err != nil || resolved == nil {
+			resolved = make(map[string]string)
+		}
+		maps.Copy(resolved, digests)
+		updated, err := json.Marshal(resolved)
+		return string(updated), err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record resolved digests on node %s: %w", nodeName, err)
+	}
+	return nil
+}
+
+func resolvedDigestsAnnotation(instanceName string) string {
+	return ResolvedDigestsAnnotationPrefix + sanitizeLabelName(instanceName)
+}
+
+func parseResolvedDigests(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var resolved map[string]string
+	if err := json.Unmarshal([]byte(value), &resolved); err != nil {
+		return nil, fmt.Errorf("malformed record of resolved digests: %w", err)
+	}
+	return resolved, nil
+}
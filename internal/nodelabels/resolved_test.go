@@ -0,0 +1,70 @@
+package nodelabels
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRecordResolvedDigests(t *testing.T) {
+	key := "resolved.image-prefetcher.stackrox.io/my-images"
+	tests := map[string]struct {
+		existing string
+		digests  map[string]string
+		expected string
+	}{
+		"first record": {
+			digests:  map[string]string{"a:v1": "sha256:1"},
+			expected: `{"a:v1":"sha256:1"}`,
+		},
+		"merged into existing record": {
+			existing: `{"a:v1":"sha256:1","b:v1":"sha256:2"}`,
+			digests:  map[string]string{"a:v1": "sha256:3", "c:v1": "sha256:4"},
+			expected: `{"a:v1":"sha256:3","b:v1":"sha256:2","c:v1":"sha256:4"}`,
+		},
+		"malformed record replaced": {
+			existing: `not json`,
+			digests:  map[string]string{"a:v1": "sha256:1"},
+			expected: `{"a:v1":"sha256:1"}`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node", Annotations: map[string]string{"other": "kept"}}}
+			if tt.existing != "" {
+				node.Annotations[key] = tt.existing
+			}
+			nodeClient := fake.NewClientset(node).CoreV1().Nodes()
+
+			require.NoError(t, recordResolvedDigestsWithClient(t.Context(), nodeClient, "node", "my-images", tt.digests))
+
+			node, err := nodeClient.Get(t.Context(), "node", metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.expected, node.Annotations[key])
+			assert.Equal(t, "kept", node.Annotations["other"])
+		})
+	}
+}
+
+func TestGetResolvedDigests(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node", Annotations: map[string]string{
+		"resolved.image-prefetcher.stackrox.io/my-images":    `{"a:v1":"sha256:1"}`,
+		"resolved.image-prefetcher.stackrox.io/other-images": `not json`,
+	}}}
+	nodeClient := fake.NewClientset(node).CoreV1().Nodes()
+
+	digests, err := getResolvedDigestsWithClient(t.Context(), nodeClient, "node", "my-images")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a:v1": "sha256:1"}, digests)
+
+	digests, err = getResolvedDigestsWithClient(t.Context(), nodeClient, "node", "no-images")
+	require.NoError(t, err)
+	assert.Empty(t, digests)
+
+	_, err = getResolvedDigestsWithClient(t.Context(), nodeClient, "node", "other-images")
+	assert.ErrorContains(t, err, "malformed record of resolved digests")
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	metricsProto "github.com/stackrox/image-prefetcher/internal/metrics/gen"
+)
+
+// PullOrder determines the order in which pulls are started.
+type PullOrder string
+
+const (
+	// PullOrderList pulls images in the order of the image list, ignoring priorities.
+	PullOrderList PullOrder = "list"
+	// PullOrderPriority pulls higher priority images first, and images of equal priority in list order.
+	PullOrderPriority PullOrder = "priority"
+	// PullOrderSmallestFirst pulls higher priority images first, and images of equal priority smallest first,
+	// using sizes reported to the metrics endpoint by earlier runs. Images of unknown size go last.
+	PullOrderSmallestFirst PullOrder = "smallest-first"
+	// PullOrderRandom pulls higher priority images first, and images of equal priority in random order,
+	// to spread the load of many nodes across registries.
+	PullOrderRandom PullOrder = "random"
+)
+
+// ParsePullOrder validates the given pull order name.
+func ParsePullOrder(s string) (PullOrder, error) {
+	switch o := PullOrder(s); o {
+	case PullOrderList, PullOrderPriority, PullOrderSmallestFirst, PullOrderRandom:
+		return o, nil
+	}
+	return "", fmt.Errorf("unknown pull order %q, expected one of %s, %s, %s or %s",
+		s, PullOrderList, PullOrderPriority, PullOrderSmallestFirst, PullOrderRandom)
+}
+
+// orderJobs sorts the jobs in place according to the given order.
+// Sizes are only used for PullOrderSmallestFirst, and randomness only for PullOrderRandom.
+func orderJobs(jobs []*pullJob, order PullOrder, sizes map[string]uint64, rng *rand.Rand) {
+	byPriority := func(a, b *pullJob) int { return cmp.Compare(b.priority, a.priority) }
+	switch order {
+	case PullOrderList:
+	case PullOrderPriority:
+		slices.SortStableFunc(jobs, byPriority)
+	case PullOrderSmallestFirst:
+		slices.SortStableFunc(jobs, func(a, b *pullJob) int {
+			return cmp.Or(byPriority(a, b), cmp.Compare(knownSizeOrMax(sizes, a.image), knownSizeOrMax(sizes, b.image)))
+		})
+	case PullOrderRandom:
+		rng.Shuffle(len(jobs), func(i, j int) { jobs[i], jobs[j] = jobs[j], jobs[i] })
+		slices.SortStableFunc(jobs, byPriority)
+	}
+}
+
+func knownSizeOrMax(sizes map[string]uint64, image string) uint64 {
+	if size, ok := sizes[image]; ok {
+		return size
+	}
+	return ^uint64(0)
+}
+
+const imageSizesTimeout = 10 * time.Second
+
+// getImageSizes fetches sizes of images pulled by earlier runs from the metrics endpoint.
+// Failure is not fatal, it just means images are pulled in priority and list order.
+func getImageSizes(ctx context.Context, logger *slog.Logger, client metricsProto.MetricsClient) map[string]uint64 {
+	if client == nil {
+		logger.WarnContext(ctx, "no metrics endpoint configured, image sizes unknown")
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, imageSizesTimeout)
+	defer cancel()
+	sizes, err := client.GetImageSizes(ctx, &metricsProto.Empty{})
+	if err != nil {
+		logger.WarnContext(ctx, "failed to get image sizes from metrics endpoint", "error", err)
+		return nil
+	}
+	logger.InfoContext(ctx, "image sizes from earlier runs", "count", len(sizes.GetSizeBytes()))
+	return sizes.GetSizeBytes()
+}
@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderJobs(t *testing.T) {
+	sizes := map[string]uint64{"a": 300, "b": 100, "c": 200}
+	tests := map[string]struct {
+		order    PullOrder
+		jobs     []*pullJob
+		expected []string
+	}{
+		"list ignores priority": {
+			order:    PullOrderList,
+			jobs:     []*pullJob{newTestJob("a", 0), newTestJob("b", 5), newTestJob("c", 0)},
+			expected: []string{"a", "b", "c"},
+		},
+		"unset order is list": {
+			jobs:     []*pullJob{newTestJob("a", 0), newTestJob("b", 5)},
+			expected: []string{"a", "b"},
+		},
+		"priority keeps list order within priority": {
+			order:    PullOrderPriority,
+			jobs:     []*pullJob{newTestJob("a", 0), newTestJob("b", 5), newTestJob("c", 0), newTestJob("d", 5)},
+			expected: []string{"b", "d", "a", "c"},
+		},
+		"smallest first": {
+			order:    PullOrderSmallestFirst,
+			jobs:     []*pullJob{newTestJob("a", 0), newTestJob("b", 0), newTestJob("c", 0)},
+			expected: []string{"b", "c", "a"},
+		},
+		"smallest first within priority, unknown sizes last": {
+			order:    PullOrderSmallestFirst,
+			jobs:     []*pullJob{newTestJob("unknown", 0), newTestJob("a", 1), newTestJob("b", 0), newTestJob("c", 1)},
+			expected: []string{"c", "a", "b", "unknown"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			orderJobs(tt.jobs, tt.order, sizes, nil)
+			assert.Equal(t, tt.expected, jobImages(tt.jobs))
+		})
+	}
+}
+
+func TestOrderJobsRandom(t *testing.T) {
+	jobs := []*pullJob{newTestJob("low", 0)}
+	for _, image := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		jobs = append(jobs, newTestJob(image, 1))
+	}
+	orderJobs(jobs, PullOrderRandom, nil, rand.New(rand.NewPCG(1, 2)))
+	images := jobImages(jobs)
+	assert.Equal(t, "low", images[len(images)-1], "priority must still be respected")
+	assert.ElementsMatch(t, []string{"a", "b", "c", "d", "e", "f", "g", "h"}, images[:len(images)-1])
+	assert.NotEqual(t, []string{"a", "b", "c", "d", "e", "f", "g", "h"}, images[:len(images)-1])
+}
+
+func TestParsePullOrder(t *testing.T) {
+	order, err := ParsePullOrder("smallest-first")
+	require.NoError(t, err)
+	assert.Equal(t, PullOrderSmallestFirst, order)
+	_, err = ParsePullOrder("largest-first")
+	assert.ErrorContains(t, err, `unknown pull order "largest-first"`)
+}
+
+func newTestJob(image string, priority int) *pullJob {
+	return &pullJob{pullTarget: pullTarget{image: image}, priority: priority}
+}
+
+func jobImages(jobs []*pullJob) []string {
+	var images []string
+	for _, job := range jobs {
+		images = append(images, job.image)
+	}
+	return images
+}
@@ -0,0 +1,184 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+)
+
+// PlanFormat is the output format of Plan.
+type PlanFormat string
+
+const (
+	// PlanFormatTable prints a human-readable table.
+	PlanFormatTable PlanFormat = "table"
+	// PlanFormatJSON prints a JSON array of entries.
+	PlanFormatJSON PlanFormat = "json"
+)
+
+// ParsePlanFormat validates the given plan output format name.
+func ParsePlanFormat(s string) (PlanFormat, error) {
+	switch f := PlanFormat(s); f {
+	case PlanFormatTable, PlanFormatJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("invalid output format %q, expected one of: %s, %s", s, PlanFormatTable, PlanFormatJSON)
+}
+
+// redacted replaces secrets in printed credentials.
+const redacted = "<redacted>"
+
+// Plan plans pulls of the given images the same way as Run, including credential lookups and checks for images
+// already present, but prints the plan to out instead of pulling. Whether images are present is shown whatever
+// their pull policy. Nothing is reported: no metrics are submitted,
+// and the node is not labeled. Tags are only resolved to digests the metrics endpoint resolved already.
+func Plan(logger *slog.Logger, config Config, out io.Writer, format PlanFormat, images ...imagelist.Image) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, config.Timing.OverallTimeout)
+	defer cancel()
+
+	f, err := newPrefetcher(logger, config)
+	if err != nil {
+		return err
+	}
+	f.dryRun = true
+	var results sync.Map // unused, since nothing is reported
+	plan := f.planJobs(ctx, images, &results, nil)
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("planning interrupted: %w", err)
+	}
+	return writePlan(out, format, planEntries(plan))
+}
+
+// planEntry describes what happens to an image for a runtime handler. Secrets are redacted.
+type planEntry struct {
+	// Order is the position in the pull order, starting at 1, or zero if the image is skipped.
+	Order int    `json:"order,omitempty"`
+	Image string `json:"image"`
+	// ListedAs is the image name as listed, if it differs from its normalized form.
+	ListedAs       string `json:"listedAs,omitempty"`
+	RuntimeHandler string `json:"runtimeHandler,omitempty"`
+	// ResolvedDigest is the digest the tag is pinned to, if tag resolution is enabled and succeeded.
+	ResolvedDigest string               `json:"resolvedDigest,omitempty"`
+	PullPolicy     imagelist.PullPolicy `json:"pullPolicy,omitempty"`
+	Priority       int                  `json:"priority,omitempty"`
+	// Present tells whether the image is already on the node, with the expected digest if any.
+	// Not set for images which do not apply to the node.
+	Present *bool `json:"present,omitempty"`
+	// Skipped tells why the image is not pulled, if it is not.
+	Skipped     skipReason       `json:"skipped,omitempty"`
+	Credentials []planCredential `json:"credentials,omitempty"`
+}
+
+// planCredential describes a credential tried for a pull, in order.
+type planCredential struct {
+	Source        credentialSource `json:"source"`
+	ServerAddress string           `json:"serverAddress,omitempty"`
+	Username      string           `json:"username,omitempty"`
+	Secret        string           `json:"secret,omitempty"`
+}
+
+func (c planCredential) String() string {
+	identity := c.Username
+	if c.ServerAddress != "" {
+		identity += "@" + c.ServerAddress
+	}
+	if identity == "" {
+		return string(c.Source)
+	}
+	return fmt.Sprintf("%s(%s)", c.Source, identity)
+}
+
+func planEntries(plan *jobPlan) []planEntry {
+	entries := make([]planEntry, 0, len(plan.jobs)+len(plan.skipped))
+	for i, job := range plan.jobs {
+		entry := newPlanEntry(job.pullTarget, plan.present)
+		entry.Order = i + 1
+		entry.ResolvedDigest = job.resolvedDigest
+		entry.PullPolicy = job.policy
+		entry.Priority = job.priority
+		for _, cred := range job.credentials {
+			entry.Credentials = append(entry.Credentials, newPlanCredential(cred))
+		}
+		entries = append(entries, entry)
+	}
+	for _, skip := range plan.skipped {
+		entry := newPlanEntry(skip.pullTarget, plan.present)
+		entry.Skipped = skip.reason
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func newPlanEntry(target pullTarget, present map[pullTarget]bool) planEntry {
+	entry := planEntry{Image: imagelist.Normalize(target.image), RuntimeHandler: target.runtimeHandler}
+	if entry.Image != target.image {
+		entry.ListedAs = target.image
+	}
+	if isPresent, ok := present[target]; ok {
+		entry.Present = &isPresent
+	}
+	return entry
+}
+
+func newPlanCredential(cred credential) planCredential {
+	c := planCredential{Source: cred.source}
+	if auth := cred.auth; auth != nil {
+		c.ServerAddress = auth.ServerAddress
+		c.Username = auth.Username
+		if auth.Password != "" || auth.Auth != "" || auth.IdentityToken != "" || auth.RegistryToken != "" {
+			c.Secret = redacted
+		}
+	}
+	return c
+}
+
+func writePlan(out io.Writer, format PlanFormat, entries []planEntry) error {
+	if format == PlanFormatJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ORDER\tIMAGE\tRUNTIME HANDLER\tPRIORITY\tCREDENTIALS\tPRESENT\tACTION")
+	pulls, skips := 0, 0
+	for _, entry := range entries {
+		order, action := "-", "skip: "+string(entry.Skipped)
+		if entry.Skipped == "" {
+			order, action = strconv.Itoa(entry.Order), "pull ("+string(entry.PullPolicy)+")"
+			pulls++
+		} else {
+			skips++
+		}
+		credentials := make([]string, 0, len(entry.Credentials))
+		for _, cred := range entry.Credentials {
+			credentials = append(credentials, cred.String())
+		}
+		var present string
+		switch {
+		case entry.Present == nil:
+			present = "-"
+		case *entry.Present:
+			present = "yes"
+		default:
+			present = "no"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", order, entry.Image, entry.RuntimeHandler, entry.Priority, strings.Join(credentials, ", "), present, action)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(out, "%d pulls planned, %d skipped.\n", pulls, skips)
+	return err
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/stackrox/image-prefetcher/internal/credentialprovider"
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	criV1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestPlan(t *testing.T) {
+	client := &fakeImageService{images: map[string]*criV1.Image{"present": {Id: "sha256:1"}, "pulled-anyway": {Id: "sha256:2"}}}
+	kr := &credentialprovider.BasicDockerKeyring{}
+	kr.Add(credentialprovider.DockerConfig{"quay.io": {Username: "user", Password: "s3cr3t"}})
+	f := &prefetcher{
+		logger:    slogt.New(t),
+		config:    Config{Timing: testTiming, PullPolicy: imagelist.PullAlways, PullOrder: PullOrderPriority},
+		criClient: client,
+		kr:        kr,
+		dryRun:    true,
+	}
+	var results sync.Map
+	plan := f.planJobs(t.Context(), []imagelist.Image{
+		{Name: "nginx"},
+		{Name: "quay.io/example/app:v1", Priority: 5},
+		{Name: "present", PullPolicy: imagelist.PullIfNotPresent},
+		{Name: "pulled-anyway"},
+		{Name: "other", Platform: "other/arch"},
+	}, &results, nil)
+	entries := planEntries(plan)
+
+	anonymous := []planCredential{{Source: credentialSourceAnonymous}}
+	assert.Equal(t, []planEntry{
+		{
+			Order: 1, Image: "quay.io/example/app:v1", PullPolicy: imagelist.PullAlways, Priority: 5, Present: ptr.To(false),
+			Credentials: []planCredential{{Source: credentialSourcePullSecret, Username: "user", Secret: redacted}},
+		},
+		{
+			Order: 2, Image: "docker.io/library/nginx:latest", ListedAs: "nginx", PullPolicy: imagelist.PullAlways, Present: ptr.To(false),
+			Credentials: anonymous,
+		},
+		{
+			Order: 3, Image: "docker.io/library/pulled-anyway:latest", ListedAs: "pulled-anyway", PullPolicy: imagelist.PullAlways, Present: ptr.To(true),
+			Credentials: anonymous,
+		},
+		{Image: "docker.io/library/other:latest", ListedAs: "other", Skipped: skipOtherPlatform},
+		{Image: "docker.io/library/present:latest", ListedAs: "present", Present: ptr.To(true), Skipped: skipAlreadyPresent},
+	}, entries)
+	assert.Empty(t, client.pulls)
+
+	t.Run("table", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, writePlan(&out, PlanFormatTable, entries))
+		assert.Contains(t, out.String(), "pull-secret(user)")
+		assert.Contains(t, out.String(), "skip: already present")
+		assert.Contains(t, out.String(), "3 pulls planned, 2 skipped.")
+		assert.Regexp(t, `pulled-anyway:latest\s.*\syes\s+pull \(Always\)`, out.String())
+		assert.NotContains(t, out.String(), "s3cr3t")
+	})
+	t.Run("json", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, writePlan(&out, PlanFormatJSON, entries))
+		assert.NotContains(t, out.String(), "s3cr3t")
+		var decoded []planEntry
+		require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+		assert.Equal(t, entries, decoded)
+	})
+}
+
+func TestParsePlanFormat(t *testing.T) {
+	format, err := ParsePlanFormat("json")
+	require.NoError(t, err)
+	assert.Equal(t, PlanFormatJSON, format)
+	_, err = ParsePlanFormat("yaml")
+	assert.Error(t, err)
+}
@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+	"github.com/stackrox/image-prefetcher/internal/registrylimits"
+
+	criV1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// pullTarget identifies what a pull produces: an image for a given runtime handler.
+type pullTarget struct {
+	image          string
+	runtimeHandler string // empty means the runtime's default handler
+}
+
+// imageSpec returns the CRI image spec for pulling or inspecting the target.
+func (t pullTarget) imageSpec() *criV1.ImageSpec {
+	return &criV1.ImageSpec{
+		Image:          t.image,
+		RuntimeHandler: t.runtimeHandler,
+	}
+}
+
+// pinnedTo returns the target for the image at the given digest, or the target itself if the digest is empty.
+func (t pullTarget) pinnedTo(digest string) pullTarget {
+	if digest == "" {
+		return t
+	}
+	return pullTarget{image: imagelist.WithDigest(t.image, digest), runtimeHandler: t.runtimeHandler}
+}
+
+// pullJob is a unit of work for the pull worker pool.
+type pullJob struct {
+	pullTarget
+	logger         *slog.Logger
+	policy         imagelist.PullPolicy
+	expectedDigest string
+	// resolvedDigest is the digest the image tag was resolved to, if it was. The image is then pulled by digest,
+	// so that all nodes get the same content even if the tag moves, and the runtime does not record it under the tag.
+	resolvedDigest string
+	priority       int
+	required       *bool
+	// Overrides of the global pull attempt timeouts, unless zero.
+	initialPullAttemptTimeout time.Duration
+	maxPullAttemptTimeout     time.Duration
+	sandboxConfig             *criV1.PodSandboxConfig
+	credentials               []credential
+	enqueued                  time.Time
+	// permit releases the registry permit acquired for the first pull attempt when the job was handed to a worker,
+	// until the attempt takes it over.
+	permit func()
+}
+
+// takePermit returns the release function of the registry permit acquired for the job, if it was not taken yet.
+func (j *pullJob) takePermit() func() {
+	permit := j.permit
+	j.permit = nil
+	return permit
+}
+
+// imageSpec returns the CRI image spec for pulling or inspecting the image of the job,
+// which is pinned to the resolved digest, if any.
+func (j *pullJob) imageSpec() *criV1.ImageSpec {
+	return j.pinnedTo(j.resolvedDigest).imageSpec()
+}
+
+// pullFunc performs a single job, given how long the job waited in the queue.
+type pullFunc func(ctx context.Context, job *pullJob, queueWait time.Duration)
+
+// runPullWorkers processes all jobs using at most maxParallel concurrent workers, in the order given.
+// A non-positive maxParallel means no limit, i.e. all jobs are started at once.
+// A job is only handed to a worker once the registry limiter permits its first pull attempt, so that jobs of
+// a saturated registry do not take up workers while waiting, and jobs of other registries get to go first.
+// Returns once all jobs are done.
+func runPullWorkers(ctx context.Context, maxParallel int, limiter *registrylimits.Limiter, jobs []*pullJob, pull pullFunc) {
+	workers := len(jobs)
+	if maxParallel > 0 && maxParallel < workers {
+		workers = maxParallel
+	}
+	now := time.Now()
+	for _, job := range jobs {
+		job.enqueued = now
+	}
+	pending := slices.Clone(jobs)
+	idle := make(chan struct{}, workers)
+	for range workers {
+		idle <- struct{}{}
+	}
+	var wg sync.WaitGroup
+	for len(pending) > 0 {
+		<-idle
+		// Obtained before trying, so that no release after trying goes unnoticed.
+		released := limiter.Released()
+		i, wait := nextPermittedJob(ctx, limiter, pending)
+		if i < 0 {
+			idle <- struct{}{}
+			waitForPermit(ctx, released, wait)
+			continue
+		}
+		job := pending[i]
+		pending = slices.Delete(pending, i, i+1)
+		wg.Go(func() {
+			defer func() { idle <- struct{}{} }()
+			pull(ctx, job, time.Since(job.enqueued))
+		})
+	}
+	wg.Wait()
+}
+
+// nextPermittedJob acquires a registry permit for the first of the jobs whose registry permits a pull, and returns
+// its index. Jobs are drained without permits once ctx is done, so that each of them gets a chance to record its
+// failure. If no registry permits a pull, it returns -1, along with the shortest wait for a rate limit,
+// or zero if only pulls in flight finishing can help.
+func nextPermittedJob(ctx context.Context, limiter *registrylimits.Limiter, jobs []*pullJob) (int, time.Duration) {
+	if ctx.Err() != nil {
+		return 0, 0
+	}
+	var shortestWait time.Duration
+	saturated := make(map[string]bool)
+	for i, job := range jobs {
+		host := registrylimits.RegistryHost(job.image)
+		if saturated[host] {
+			continue
+		}
+		release, wait, ok := limiter.TryAcquire(job.image)
+		if ok {
+			job.permit = release
+			return i, 0
+		}
+		saturated[host] = true
+		if wait > 0 && (shortestWait == 0 || wait < shortestWait) {
+			shortestWait = wait
+		}
+	}
+	return -1, shortestWait
+}
+
+// waitForPermit waits until a registry permit was released, the given wait is over unless it is zero, or ctx is done.
+func waitForPermit(ctx context.Context, released <-chan struct{}, wait time.Duration) {
+	var timer <-chan time.Time
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		timer = t.C
+	}
+	select {
+	case <-released:
+	case <-timer:
+	case <-ctx.Done():
+	}
+}
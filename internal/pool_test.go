@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stackrox/image-prefetcher/internal/registrylimits"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunPullWorkers(t *testing.T) {
+	tests := map[string]struct {
+		maxParallel  int
+		jobs         int
+		expectedPeak int32
+	}{
+		"no jobs": {
+			maxParallel: 2,
+		},
+		"unlimited": {
+			jobs:         5,
+			expectedPeak: 5,
+		},
+		"limited": {
+			maxParallel:  2,
+			jobs:         5,
+			expectedPeak: 2,
+		},
+		"limit above job count": {
+			maxParallel:  10,
+			jobs:         3,
+			expectedPeak: 3,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var jobs []*pullJob
+			for range test.jobs {
+				jobs = append(jobs, &pullJob{})
+			}
+			var inFlight, peak atomic.Int32
+			var mutex sync.Mutex
+			done := map[*pullJob]time.Duration{}
+			// Make sure all workers have a chance to start before any of them finish.
+			barrier := make(chan struct{})
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				close(barrier)
+			}()
+			runPullWorkers(t.Context(), test.maxParallel, nil, jobs, func(_ context.Context, job *pullJob, queueWait time.Duration) {
+				current := inFlight.Add(1)
+				for {
+					old := peak.Load()
+					if current <= old || peak.CompareAndSwap(old, current) {
+						break
+					}
+				}
+				<-barrier
+				inFlight.Add(-1)
+				mutex.Lock()
+				defer mutex.Unlock()
+				done[job] = queueWait
+			})
+			assert.Len(t, done, test.jobs)
+			assert.Equal(t, test.expectedPeak, peak.Load())
+			for _, job := range jobs {
+				assert.Contains(t, done, job)
+				assert.False(t, job.enqueued.IsZero())
+			}
+		})
+	}
+}
+
+func TestRunPullWorkersSkipsSaturatedRegistry(t *testing.T) {
+	limiter := registrylimits.NewLimiter(registrylimits.Config{Registries: map[string]registrylimits.Limits{"quay.io": {MaxParallelPulls: 1}}})
+	jobs := []*pullJob{
+		{pullTarget: pullTarget{image: "quay.io/a"}},
+		{pullTarget: pullTarget{image: "quay.io/b"}},
+		{pullTarget: pullTarget{image: "docker.io/library/c"}},
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	otherRegistryDone := make(chan struct{})
+	var mutex sync.Mutex
+	var done []string
+	runPullWorkers(ctx, 2, limiter, jobs, func(ctx context.Context, job *pullJob, _ time.Duration) {
+		release := job.takePermit()
+		if release == nil {
+			var err error
+			release, err = limiter.Acquire(ctx, job.image)
+			if err != nil {
+				return
+			}
+		}
+		defer release()
+		switch job.image {
+		case "quay.io/a":
+			// Holds the only slot of its registry until the image of the other registry is pulled.
+			select {
+			case <-otherRegistryDone:
+			case <-ctx.Done():
+			}
+		case "docker.io/library/c":
+			close(otherRegistryDone)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		done = append(done, job.image)
+	})
+	assert.NoError(t, ctx.Err())
+	assert.Equal(t, []string{"docker.io/library/c", "quay.io/a", "quay.io/b"}, done)
+}
@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+
+	"github.com/stackrox/image-prefetcher/internal/credentialprovider"
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+	metricsProto "github.com/stackrox/image-prefetcher/internal/metrics/gen"
+	"github.com/stackrox/image-prefetcher/internal/metrics/submitter"
+	"github.com/stackrox/image-prefetcher/internal/nodelabels"
+	"github.com/stackrox/image-prefetcher/internal/registrylimits"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	criV1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// prefetcher holds the clients and credentials shared by all pulls of a process.
+type prefetcher struct {
+	logger          *slog.Logger
+	config          Config
+	criClient       criV1.ImageServiceClient
+	metricsClient   metricsProto.MetricsClient // nil if no metrics endpoint is configured
+	pluginKr        *credentialprovider.PluginKeyring
+	kr              *credentialprovider.BasicDockerKeyring
+	registryLimiter *registrylimits.Limiter
+	// freeSpace measures space available on the filesystem containing a path. Nil if it cannot be measured.
+	freeSpace func(path string) (uint64, error)
+	// All pulls of a process appear to come from the same synthetic pod.
+	sandboxUID string
+	// dryRun is set when only planning pulls, so that planning leaves no state behind elsewhere.
+	dryRun bool
+}
+
+func newPrefetcher(logger *slog.Logger, config Config) (*prefetcher, error) {
+	criConn, err := grpc.NewClient("unix://"+config.CRISocketPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial CRI socket %q: %w", config.CRISocketPath, err)
+	}
+
+	var metricsClient metricsProto.MetricsClient
+	if metricsEndpoint := config.MetricsEndpoint; metricsEndpoint != "" {
+		metricsConn, err := grpc.NewClient(metricsEndpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial metrics endpoint %q: %w", metricsEndpoint, err)
+		}
+		metricsClient = metricsProto.NewMetricsClient(metricsConn)
+	}
+
+	// Initialize credential provider plugin keyring if configured
+	pluginKr, err := credentialprovider.NewPluginKeyring(logger, config.CredentialProviderConfig, config.CredentialProviderBinDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize credential provider plugins: %w", err)
+	}
+
+	kr := &credentialprovider.BasicDockerKeyring{}
+	if err := loadPullSecret(logger, kr, config.DockerConfigJSONPath); err != nil {
+		return nil, fmt.Errorf("failed to load image pull secrets: %w", err)
+	}
+
+	return &prefetcher{
+		logger:          logger,
+		config:          config,
+		criClient:       criV1.NewImageServiceClient(criConn),
+		metricsClient:   metricsClient,
+		pluginKr:        pluginKr,
+		kr:              kr,
+		registryLimiter: registrylimits.NewLimiter(config.RegistryLimits),
+		freeSpace:       statfsAvailableBytes,
+		sandboxUID:      uuid.NewString(),
+	}, nil
+}
+
+// startMetricsSink starts submitting metrics sent to the returned sink until ctx is done,
+// or returns nil if no metrics endpoint is configured.
+func (f *prefetcher) startMetricsSink(ctx context.Context) *submitter.Submitter {
+	if f.metricsClient == nil {
+		return nil
+	}
+	metricsSink := submitter.NewSubmitter(f.logger, f.metricsClient)
+	go func() { _ = metricsSink.Run(ctx) }() // Returned error is for testing, sink already handles errors.
+	return metricsSink
+}
+
+func (f *prefetcher) newPuller(metricsSink chan<- *metricsProto.Result, results *sync.Map, failures *sync.Map) *puller {
+	return &puller{
+		client:          f.criClient,
+		registryLimiter: f.registryLimiter,
+		metricsSink:     metricsSink,
+		timing:          f.config.Timing,
+		results:         results,
+		failures:        failures,
+	}
+}
+
+// skipReason tells why an image is not pulled.
+type skipReason string
+
+const (
+	skipOtherPlatform  skipReason = "other platform"
+	skipNotSelected    skipReason = "not selected for this node"
+	skipAlreadyPresent skipReason = "already present"
+)
+
+// skippedImage is an image which is not pulled for a runtime handler, or for any if runtimeHandler is empty.
+type skippedImage struct {
+	pullTarget
+	reason skipReason
+}
+
+// jobPlan is the outcome of planning pulls of a list of images.
+type jobPlan struct {
+	// jobs are the pulls to perform, in pull order.
+	jobs []*pullJob
+	// skipped are the images which are not pulled.
+	skipped []skippedImage
+	// resolvedDigests are the digests which image tags are pinned to, by image name, if tag resolution is enabled.
+	resolvedDigests map[string]string
+	// sizes are image sizes reported by earlier pulls, by image name, if they were needed.
+	sizes map[string]uint64
+	// present tells, in dry runs only, whether the image of each target which applies to the node is already present.
+	present map[pullTarget]bool
+}
+
+// planJobs plans pull jobs for the images which apply to this node.
+// Pulls skipped because the image is already present are also recorded in results.
+func (f *prefetcher) planJobs(ctx context.Context, images []imagelist.Image, results *sync.Map, metricsSink chan<- *metricsProto.Result) *jobPlan {
+	logger, config := f.logger, f.config
+	plan := &jobPlan{present: make(map[pullTarget]bool)}
+	var applicable []imagelist.Image
+	selectedForNode := nodeSelectorFilter(ctx, logger, images, nodelabels.GetNodeLabels)
+	for _, image := range images {
+		if !platformMatches(image.Platform) {
+			logger.InfoContext(ctx, "skipping image for another platform", "image", image.Name, "platform", image.Platform)
+			plan.skipped = append(plan.skipped, skippedImage{pullTarget: pullTarget{image: image.Name}, reason: skipOtherPlatform})
+			continue
+		}
+		if !selectedForNode(image) {
+			logger.InfoContext(ctx, "skipping image not selected for this node", "image", image.Name, "nodeSelector", image.NodeSelector)
+			plan.skipped = append(plan.skipped, skippedImage{pullTarget: pullTarget{image: image.Name}, reason: skipNotSelected})
+			continue
+		}
+		applicable = append(applicable, image)
+	}
+	plan.resolvedDigests = f.resolveTags(ctx, applicable)
+	for _, image := range applicable {
+		sandboxConfig := config.Sandbox.podSandboxConfig(SandboxConfig{
+			Namespace:   image.SandboxNamespace,
+			Labels:      image.SandboxLabels,
+			Annotations: image.SandboxAnnotations,
+		}, f.sandboxUID)
+		credentials := getCredentialsForImage(ctx, logger, f.pluginKr, f.kr, image.Name, config.AnonymousFallback)
+		resolvedDigest := plan.resolvedDigests[image.Name]
+		for _, handler := range runtimeHandlers(image, config) {
+			job := &pullJob{
+				pullTarget:                pullTarget{image: image.Name, runtimeHandler: handler},
+				logger:                    logger.With("image", image.Name),
+				policy:                    cmp.Or(image.PullPolicy, config.PullPolicy),
+				expectedDigest:            cmp.Or(image.ExpectedDigest, resolvedDigest),
+				resolvedDigest:            resolvedDigest,
+				priority:                  image.Priority,
+				required:                  image.Required,
+				initialPullAttemptTimeout: image.InitialPullAttemptTimeout,
+				maxPullAttemptTimeout:     image.MaxPullAttemptTimeout,
+				sandboxConfig:             sandboxConfig,
+				credentials:               credentials,
+			}
+			if handler != "" {
+				job.logger = job.logger.With("runtimeHandler", handler)
+			}
+			if resolvedDigest != "" {
+				job.logger = job.logger.With("resolvedDigest", resolvedDigest)
+			}
+			// Dry runs show whether images are present regardless of their pull policy.
+			if job.policy == imagelist.PullIfNotPresent || f.dryRun {
+				status := presentImage(ctx, job, f.criClient, config.Timing.ImageListTimeout)
+				if f.dryRun {
+					plan.present[job.pullTarget] = status != nil
+				}
+				if status != nil && job.policy == imagelist.PullIfNotPresent {
+					job.logger.InfoContext(ctx, "image already present, skipping pull", "imageID", status.Id)
+					noteAlreadyPresent(metricsSink, job, status.Size)
+					results.Store(job.pullTarget, nodelabels.OutcomeAlreadyPresent)
+					plan.skipped = append(plan.skipped, skippedImage{pullTarget: job.pullTarget, reason: skipAlreadyPresent})
+					continue
+				}
+			}
+			plan.jobs = append(plan.jobs, job)
+		}
+	}
+	if config.PullOrder == PullOrderSmallestFirst || config.DiskSpace.MinFreeBytes > 0 {
+		plan.sizes = getImageSizes(ctx, logger, f.metricsClient)
+	}
+	orderJobs(plan.jobs, config.PullOrder, plan.sizes, rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())))
+	return plan
+}
@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+	"github.com/stackrox/image-prefetcher/internal/nodelabels"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	criV1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// PruneConfig configures Prune.
+type PruneConfig struct {
+	CRISocketPath string
+	// RuntimeHandlers for listed images which do not specify their own, as in Config.
+	RuntimeHandlers []string
+	Timeout         time.Duration
+	// DryRun only prints which images would be removed.
+	DryRun bool
+}
+
+// Prune removes images recorded as pulled by this instance which are no longer listed, and prints what it did to out.
+// Images in use by containers are kept, as are images which are also known under a listed name.
+func Prune(logger *slog.Logger, config PruneConfig, out io.Writer, listed ...imagelist.Image) error {
+	if len(listed) == 0 {
+		return errors.New("refusing to prune with an empty image list, as it would remove all images pulled so far")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+
+	conn, err := grpc.NewClient("unix://"+config.CRISocketPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("failed to dial CRI socket %q: %w", config.CRISocketPath, err)
+	}
+	pulled, err := nodelabels.GetPulledImages(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get images pulled earlier: %w", err)
+	}
+	resolved, err := nodelabels.GetResolvedDigests(ctx)
+	if err != nil {
+		logger.WarnContext(ctx, "failed to get digests resolved earlier, images pulled by digest are not found", "error", err)
+	}
+	p := &pruner{
+		logger:          logger,
+		images:          criV1.NewImageServiceClient(conn),
+		containers:      criV1.NewRuntimeServiceClient(conn),
+		config:          config,
+		resolvedDigests: resolved,
+	}
+	forgotten, err := p.prune(ctx, out, pulled, listed)
+	if err != nil {
+		return err
+	}
+	if config.DryRun {
+		return nil
+	}
+	return nodelabels.RecordPulledImages(ctx, logger, nil, forgotten)
+}
+
+// pruneAction is what happens to an image pulled earlier.
+type pruneAction string
+
+const (
+	pruneRemove      pruneAction = "remove"
+	pruneWouldRemove pruneAction = "would remove"
+	pruneGone        pruneAction = "already gone"
+	pruneInUse       pruneAction = "keep: in use"
+	pruneListed      pruneAction = "keep: same image as a listed one"
+)
+
+type pruner struct {
+	logger     *slog.Logger
+	images     criV1.ImageServiceClient
+	containers criV1.RuntimeServiceClient
+	config     PruneConfig
+	// resolvedDigests are the digests image tags were pinned to, by image name.
+	resolvedDigests map[string]string
+}
+
+// prune removes images pulled earlier which are not listed any more, unless they are protected, and prints a table of
+// what it did, or would do in dry-run mode. Returns images which should no longer be recorded as pulled.
+func (p *pruner) prune(ctx context.Context, out io.Writer, pulled []nodelabels.PulledImage, listed []imagelist.Image) ([]nodelabels.PulledImage, error) {
+	listedTargets := map[pullTarget]bool{}
+	for _, image := range listed {
+		for _, handler := range runtimeHandlers(image, Config{RuntimeHandlers: p.config.RuntimeHandlers}) {
+			listedTargets[pullTarget{image: image.Name, runtimeHandler: handler}] = true
+		}
+	}
+	var stale []pullTarget
+	for _, image := range pulled {
+		if target := (pullTarget{image: image.Image, runtimeHandler: image.RuntimeHandler}); !listedTargets[target] {
+			stale = append(stale, target)
+		}
+	}
+	if len(stale) == 0 {
+		_, err := fmt.Fprintln(out, "No images to prune.")
+		return nil, err
+	}
+
+	// An image may be known under several names, so compare identifiers rather than names.
+	protected, err := p.imagesInUse(ctx)
+	if err != nil {
+		return nil, err
+	}
+	listedIDs := map[pullTarget]bool{}
+	for target := range listedTargets {
+		_, status, err := p.imageStatus(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		if status != nil {
+			listedIDs[pullTarget{image: status.Id, runtimeHandler: target.runtimeHandler}] = true
+		}
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "IMAGE\tRUNTIME HANDLER\tIMAGE ID\tACTION")
+	var forgotten []nodelabels.PulledImage
+	for _, target := range stale {
+		logger := p.logger.With("image", target.image, "runtimeHandler", target.runtimeHandler)
+		found, status, err := p.imageStatus(ctx, target)
+		if err != nil {
+			logger.ErrorContext(ctx, "skipping image", "error", err)
+			continue
+		}
+		var action pruneAction
+		switch {
+		case status == nil:
+			action = pruneGone
+		case isInUse(status, protected):
+			action = pruneInUse
+		case listedIDs[pullTarget{image: status.Id, runtimeHandler: target.runtimeHandler}]:
+			action = pruneListed
+		case p.config.DryRun:
+			action = pruneWouldRemove
+		default:
+			action = pruneRemove
+			if err := p.remove(ctx, found); err != nil {
+				logger.ErrorContext(ctx, "failed to remove image", "error", err)
+				continue
+			}
+			logger.InfoContext(ctx, "removed image", "imageID", status.Id)
+		}
+		if action == pruneGone || action == pruneRemove {
+			forgotten = append(forgotten, nodelabels.PulledImage{Image: target.image, RuntimeHandler: target.runtimeHandler})
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", target.image, target.runtimeHandler, status.GetId(), action)
+	}
+	return forgotten, w.Flush()
+}
+
+// imagesInUse returns references to images used by any containers, whether running or not.
+func (p *pruner) imagesInUse(ctx context.Context) (map[string]bool, error) {
+	resp, err := p.containers.ListContainers(ctx, &criV1.ListContainersRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers, cannot tell which images are in use: %w", err)
+	}
+	inUse := map[string]bool{}
+	for _, container := range resp.GetContainers() {
+		for _, ref := range []string{container.GetImageRef(), container.GetImageId(), container.GetImage().GetImage()} {
+			if ref != "" {
+				inUse[ref] = true
+			}
+		}
+	}
+	return inUse, nil
+}
+
+func isInUse(image *criV1.Image, inUse map[string]bool) bool {
+	if inUse[image.GetId()] {
+		return true
+	}
+	for _, refs := range [][]string{image.GetRepoTags(), image.GetRepoDigests()} {
+		for _, ref := range refs {
+			if inUse[ref] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// imageStatus returns the status of the image of the target, or nil if it is not present, along with the target it
+// was found as. Images whose tags were pinned to a digest are not recorded under their tags by the runtime,
+// so they are also looked up by the digest.
+func (p *pruner) imageStatus(ctx context.Context, target pullTarget) (pullTarget, *criV1.Image, error) {
+	candidates := []pullTarget{target}
+	if digest := p.resolvedDigests[target.image]; digest != "" {
+		candidates = append(candidates, target.pinnedTo(digest))
+	}
+	for _, candidate := range candidates {
+		resp, err := p.images.ImageStatus(ctx, &criV1.ImageStatusRequest{Image: candidate.imageSpec()})
+		if err != nil {
+			return target, nil, fmt.Errorf("failed to obtain status of image %s: %w", candidate.image, err)
+		}
+		if image := resp.GetImage(); image != nil {
+			return candidate, image, nil
+		}
+	}
+	return target, nil, nil
+}
+
+func (p *pruner) remove(ctx context.Context, target pullTarget) error {
+	_, err := p.images.RemoveImage(ctx, &criV1.RemoveImageRequest{Image: target.imageSpec()})
+	return err
+}
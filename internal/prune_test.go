@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+	"github.com/stackrox/image-prefetcher/internal/nodelabels"
+
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	criV1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+func (f *fakeImageService) RemoveImage(_ context.Context, in *criV1.RemoveImageRequest, _ ...grpc.CallOption) (*criV1.RemoveImageResponse, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.removals = append(f.removals, in.GetImage().GetImage())
+	delete(f.images, in.GetImage().GetImage())
+	return &criV1.RemoveImageResponse{}, nil
+}
+
+type fakeRuntimeService struct {
+	criV1.RuntimeServiceClient // unimplemented methods panic
+	containers                 []*criV1.Container
+}
+
+func (f *fakeRuntimeService) ListContainers(context.Context, *criV1.ListContainersRequest, ...grpc.CallOption) (*criV1.ListContainersResponse, error) {
+	return &criV1.ListContainersResponse{Containers: f.containers}, nil
+}
+
+func TestPrune(t *testing.T) {
+	images := func() map[string]*criV1.Image {
+		return map[string]*criV1.Image{
+			"listed":          {Id: "sha256:1"},
+			"stale":           {Id: "sha256:2"},
+			"running":         {Id: "sha256:3", RepoDigests: []string{"quay.io/running@sha256:33"}},
+			"listed-too":      {Id: "sha256:1"},
+			"pinned@sha256:4": {Id: "sha256:4"},
+		}
+	}
+	tests := map[string]struct {
+		pulled            []nodelabels.PulledImage
+		dryRun            bool
+		expectedRemovals  []string
+		expectedForgotten []nodelabels.PulledImage
+		expectedOutput    []string
+	}{
+		"nothing to prune": {
+			pulled:         []nodelabels.PulledImage{{Image: "listed"}},
+			expectedOutput: []string{"No images to prune."},
+		},
+		"stale image removed": {
+			pulled:            []nodelabels.PulledImage{{Image: "listed"}, {Image: "stale"}},
+			expectedRemovals:  []string{"stale"},
+			expectedForgotten: []nodelabels.PulledImage{{Image: "stale"}},
+			expectedOutput:    []string{"stale", "sha256:2", "remove"},
+		},
+		"dry run": {
+			pulled:         []nodelabels.PulledImage{{Image: "stale"}},
+			dryRun:         true,
+			expectedOutput: []string{"stale", "would remove"},
+		},
+		"protected images kept": {
+			pulled:         []nodelabels.PulledImage{{Image: "running"}, {Image: "listed-too"}},
+			expectedOutput: []string{"keep: in use", "keep: same image as a listed one"},
+		},
+		"gone image forgotten": {
+			pulled:            []nodelabels.PulledImage{{Image: "gone"}},
+			expectedForgotten: []nodelabels.PulledImage{{Image: "gone"}},
+			expectedOutput:    []string{"already gone"},
+		},
+		"image pulled by resolved digest removed": {
+			pulled:            []nodelabels.PulledImage{{Image: "pinned"}},
+			expectedRemovals:  []string{"pinned@sha256:4"},
+			expectedForgotten: []nodelabels.PulledImage{{Image: "pinned"}},
+			expectedOutput:    []string{"pinned", "sha256:4", "remove"},
+		},
+		"image pulled for other runtime handler removed": {
+			pulled:            []nodelabels.PulledImage{{Image: "listed", RuntimeHandler: "kata"}},
+			expectedRemovals:  []string{"listed"},
+			expectedForgotten: []nodelabels.PulledImage{{Image: "listed", RuntimeHandler: "kata"}},
+			expectedOutput:    []string{"kata", "remove"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			imageService := &fakeImageService{images: images()}
+			p := &pruner{
+				logger: slogt.New(t),
+				images: imageService,
+				containers: &fakeRuntimeService{containers: []*criV1.Container{
+					{ImageRef: "quay.io/running@sha256:33"},
+				}},
+				config:          PruneConfig{DryRun: tt.dryRun},
+				resolvedDigests: map[string]string{"pinned": "sha256:4"},
+			}
+			var out bytes.Buffer
+
+			forgotten, err := p.prune(t.Context(), &out, tt.pulled, []imagelist.Image{{Name: "listed"}})
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedRemovals, imageService.removals)
+			assert.Equal(t, tt.expectedForgotten, forgotten)
+			for _, s := range tt.expectedOutput {
+				assert.Contains(t, out.String(), s)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// Package pullerrors classifies errors returned by CRI image pulls, to decide whether retrying makes sense.
+//
+// Container runtimes mostly return registry errors with the gRPC Unknown code, so apart from the status code,
+// classification relies on well-known fragments of the error messages produced by containerd, CRI-O and registries.
+package pullerrors
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Class is a category of pull errors.
+type Class string
+
+const (
+	// ClassNotFound means the image, tag or repository does not exist. Permanent.
+	ClassNotFound Class = "not-found"
+	// ClassUnauthorized means the registry rejected the credentials, or lack thereof. Permanent.
+	ClassUnauthorized Class = "unauthorized"
+	// ClassInvalidReference means the image name cannot be parsed. Permanent.
+	ClassInvalidReference Class = "invalid-reference"
+	// ClassDigestMismatch means the image was pulled, but did not have the expected digest. Permanent.
+	ClassDigestMismatch Class = "digest-mismatch"
+	// ClassNoSpace means the image filesystem of the node ran out of space, or the pull was skipped to keep
+	// the configured minimum of free space. Permanent.
+	ClassNoSpace Class = "no-space"
+	// ClassDeadline means the attempt timed out or was cancelled. Transient.
+	ClassDeadline Class = "deadline"
+	// ClassUnavailable means the runtime or registry could not be reached. Transient.
+	ClassUnavailable Class = "unavailable"
+	// ClassRateLimited means the registry asked to slow down, e.g. with HTTP status 429. Transient.
+	ClassRateLimited Class = "rate-limited"
+	// ClassServerError means the registry returned HTTP status 5xx. Transient.
+	ClassServerError Class = "server-error"
+	// ClassUnknown is any other error. Treated as transient.
+	ClassUnknown Class = "unknown"
+)
+
+// Permanent returns whether retrying a pull which failed with an error of this class is pointless.
+func (c Class) Permanent() bool {
+	switch c {
+	case ClassNotFound, ClassUnauthorized, ClassInvalidReference, ClassDigestMismatch, ClassNoSpace:
+		return true
+	}
+	return false
+}
+
+// ErrDigestMismatch is wrapped by errors reporting a pulled image without the expected digest.
+var ErrDigestMismatch = errors.New("digest mismatch")
+
+// ErrNoSpace is wrapped by errors reporting a pull skipped to keep free space on the image filesystem.
+var ErrNoSpace = errors.New("insufficient disk space")
+
+// messageFragments maps lowercase error message fragments to classes.
+// Checked in order, since messages may contain fragments of several classes.
+// A "*" in a fragment matches anything, so that its parts only match in order.
+// Bare HTTP status numbers are avoided, since they may also appear inside digests. Generic wording such as "denied"
+// or "not found" is only matched in registry context, since it also appears in errors of the node, e.g.
+// "permission denied" on a snapshotter mount, or an unpacking helper "executable file not found".
+var messageFragments = []struct {
+	class     Class
+	fragments []string
+}{
+	{ClassNoSpace, []string{"no space left on device", "disk quota exceeded"}},
+	{ClassRateLimited, []string{"too many requests", "toomanyrequests", "rate limit"}},
+	{ClassServerError, []string{"500 internal server error", "502 bad gateway", "503 service unavailable", "504 gateway timeout", "unexpected status code 5", "unexpected status: 5"}},
+	{ClassUnauthorized, []string{"unauthorized", "authentication required", "forbidden", "access denied", "requested access to the resource is denied", "authorization token has expired"}},
+	{ClassNotFound, []string{"failed to resolve reference*: not found", "404 not found", "manifest unknown", "name unknown", "no such image"}},
+	{ClassInvalidReference, []string{"invalid reference format", "repository name must", "invalid image name", "failed to parse image name"}},
+	{ClassUnavailable, []string{"i/o timeout", "connection reset", "connection refused", "no such host", "tls handshake timeout", "unexpected eof", "broken pipe"}},
+	{ClassDeadline, []string{"deadline exceeded", "context canceled"}},
+}
+
+// Classify determines the class of an error returned by a pull.
+func Classify(err error) Class {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, ErrDigestMismatch) {
+		return ClassDigestMismatch
+	}
+	if errors.Is(err, ErrNoSpace) {
+		return ClassNoSpace
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return ClassDeadline
+	}
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.Canceled:
+		return ClassDeadline
+	case codes.Unavailable:
+		return ClassUnavailable
+	case codes.NotFound:
+		return ClassNotFound
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return ClassUnauthorized
+	case codes.InvalidArgument:
+		return ClassInvalidReference
+	case codes.ResourceExhausted:
+		return ClassRateLimited
+	}
+	message := strings.ToLower(err.Error())
+	for _, candidate := range messageFragments {
+		for _, fragment := range candidate.fragments {
+			if containsInOrder(message, strings.Split(fragment, "*")) {
+				return candidate.class
+			}
+		}
+	}
+	return ClassUnknown
+}
+
+// containsInOrder returns whether message contains all parts, without overlap, in the given order.
+func containsInOrder(message string, parts []string) bool {
+	for _, part := range parts {
+		_, rest, found := strings.Cut(message, part)
+		if !found {
+			return false
+		}
+		message = rest
+	}
+	return true
+}
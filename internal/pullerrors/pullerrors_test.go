@@ -0,0 +1,122 @@
+package pullerrors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassify(t *testing.T) {
+	tests := map[string]struct {
+		err       error
+		expected  Class
+		permanent bool
+	}{
+		"nil": {
+			err: nil,
+		},
+		"context deadline": {
+			err:      fmt.Errorf("waiting for registry limiter: %w", context.DeadlineExceeded),
+			expected: ClassDeadline,
+		},
+		"grpc deadline": {
+			err:      status.Error(codes.DeadlineExceeded, "context deadline exceeded"),
+			expected: ClassDeadline,
+		},
+		"grpc unavailable": {
+			err:      status.Error(codes.Unavailable, "connection error"),
+			expected: ClassUnavailable,
+		},
+		"grpc not found": {
+			err:       status.Error(codes.NotFound, "image not found"),
+			expected:  ClassNotFound,
+			permanent: true,
+		},
+		"containerd not found": {
+			err:       status.Error(codes.Unknown, `failed to pull and unpack image "docker.io/library/nginx:nope": failed to resolve reference "docker.io/library/nginx:nope": docker.io/library/nginx:nope: not found`),
+			expected:  ClassNotFound,
+			permanent: true,
+		},
+		"containerd unauthorized": {
+			err:       status.Error(codes.Unknown, `failed to pull and unpack image "quay.io/private/img:1": failed to resolve reference "quay.io/private/img:1": pulling from host quay.io failed with status code [manifests 1]: 401 UNAUTHORIZED`),
+			expected:  ClassUnauthorized,
+			permanent: true,
+		},
+		"cri-o denied": {
+			err:       status.Error(codes.Unknown, `initializing source docker://quay.io/private/img:1: reading manifest 1 in quay.io/private/img: unauthorized: access to the requested resource is not authorized`),
+			expected:  ClassUnauthorized,
+			permanent: true,
+		},
+		"docker hub denied": {
+			err:       status.Error(codes.Unknown, `failed to pull and unpack image "docker.io/private/img:1": failed to resolve reference "docker.io/private/img:1": pull access denied, repository does not exist or may require authorization: server message: insufficient_scope: authorization failed`),
+			expected:  ClassUnauthorized,
+			permanent: true,
+		},
+		"ecr denied": {
+			err:       status.Error(codes.Unknown, `initializing source docker://123.dkr.ecr.us-east-1.amazonaws.com/img:1: reading manifest 1 in 123.dkr.ecr.us-east-1.amazonaws.com/img: denied: requested access to the resource is denied`),
+			expected:  ClassUnauthorized,
+			permanent: true,
+		},
+		"cri-o manifest unknown": {
+			err:       status.Error(codes.Unknown, `initializing source docker://quay.io/a/b:nope: reading manifest nope in quay.io/a/b: manifest unknown`),
+			expected:  ClassNotFound,
+			permanent: true,
+		},
+		"local permission denied": {
+			err:      status.Error(codes.Unknown, `failed to pull and unpack image "quay.io/a/b:c": failed to extract layer sha256:abc: mount callback failed on /var/lib/containerd/tmpmounts/containerd-mount123: open /var/lib/containerd/tmpmounts/containerd-mount123/etc: permission denied`),
+			expected: ClassUnknown,
+		},
+		"local executable not found": {
+			err:      status.Error(codes.Unknown, `failed to pull and unpack image "quay.io/a/b:c": failed to extract layer sha256:abc: exec: "unpigz": executable file not found in $PATH`),
+			expected: ClassUnknown,
+		},
+		"invalid reference": {
+			err:       status.Error(codes.Unknown, `failed to pull and unpack image "Foo:bar": failed to resolve reference "Foo:bar": parse "dummy://Foo:bar": invalid reference format`),
+			expected:  ClassInvalidReference,
+			permanent: true,
+		},
+		"rate limited": {
+			err:      status.Error(codes.Unknown, `failed to pull and unpack image "docker.io/library/nginx:latest": failed to copy: httpReadSeeker: failed open: unexpected status code https://registry-1.docker.io/v2/library/nginx/manifests/sha256:abc: 429 Too Many Requests - Server message: toomanyrequests: You have reached your pull rate limit.`),
+			expected: ClassRateLimited,
+		},
+		"server error": {
+			err:      status.Error(codes.Unknown, `failed to pull and unpack image "quay.io/a/b:c": failed to resolve reference "quay.io/a/b:c": unexpected status from HEAD request to https://quay.io/v2/a/b/manifests/c: 503 Service Unavailable`),
+			expected: ClassServerError,
+		},
+		"network error": {
+			err:      status.Error(codes.Unknown, `failed to do request: Head "https://quay.io/v2/a/b/manifests/c": dial tcp: lookup quay.io: i/o timeout`),
+			expected: ClassUnavailable,
+		},
+		"digest mismatch": {
+			err:       fmt.Errorf("image has digests [quay.io/a/b@sha256:abc]: %w", ErrDigestMismatch),
+			expected:  ClassDigestMismatch,
+			permanent: true,
+		},
+		"no space left": {
+			err:       status.Error(codes.Unknown, `failed to pull and unpack image "quay.io/a/b:c": failed to extract layer sha256:abc: write /var/lib/containerd/tmpmounts/x: no space left on device`),
+			expected:  ClassNoSpace,
+			permanent: true,
+		},
+		"skipped for disk space": {
+			err:       fmt.Errorf("image needs 100 bytes: %w", ErrNoSpace),
+			expected:  ClassNoSpace,
+			permanent: true,
+		},
+		"something else": {
+			err:      errors.New("disk full"),
+			expected: ClassUnknown,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			class := Classify(test.err)
+			assert.Equal(t, test.expected, class)
+			assert.Equal(t, test.permanent, class.Permanent())
+		})
+	}
+}
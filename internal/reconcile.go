@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+	"github.com/stackrox/image-prefetcher/internal/nodelabels"
+
+	criV1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// reconcile checks whether the listed images which apply to this node are still present, and queues those which are not
+// to be pulled again, such as after kubelet image garbage collection removed them. Until they are pulled again,
+// they are recorded as missing in status, and the node is labeled accordingly.
+func (f *prefetcher) reconcile(ctx context.Context, images []imagelist.Image, queue *imageQueue, status *nodeStatus) {
+	logger := f.logger
+	selectedForNode := nodeSelectorFilter(ctx, logger, images, nodelabels.GetNodeLabels)
+	var missing []imagelist.Image
+	for _, image := range images {
+		if !platformMatches(image.Platform) || !selectedForNode(image) {
+			continue
+		}
+		isMissing := false
+		for _, handler := range runtimeHandlers(image, f.config) {
+			target := pullTarget{image: image.Name, runtimeHandler: handler}
+			present, err := f.isPresent(ctx, target.pinnedTo(status.resolvedDigest(image.Name)))
+			if err != nil {
+				// Only images known to be missing are pulled again.
+				logger.WarnContext(ctx, "failed to check whether image is present", "image", image.Name, "runtimeHandler", handler, "error", err)
+				continue
+			}
+			if !present {
+				status.results.Store(target, nodelabels.OutcomeMissing)
+				isMissing = true
+			}
+		}
+		if isMissing {
+			// The image keeps its pull policy, so that if it was pulled again since it was checked, it is still
+			// recorded as pulled rather than as already present.
+			missing = append(missing, image)
+		}
+	}
+	if len(missing) == 0 {
+		logger.DebugContext(ctx, "all listed images are present")
+		return
+	}
+	logger.WarnContext(ctx, "listed images are missing, pulling them again", "count", len(missing))
+	labelCtx, cancel := context.WithTimeout(ctx, f.config.Timing.ReportingTimeout)
+	defer cancel()
+	status.patchNodeLabels(labelCtx, logger)
+	queue.add(missing...)
+}
+
+// isPresent returns whether the image of the target is present on the node.
+func (f *prefetcher) isPresent(ctx context.Context, target pullTarget) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.config.Timing.ImageListTimeout)
+	defer cancel()
+	resp, err := f.criClient.ImageStatus(ctx, &criV1.ImageStatusRequest{Image: target.imageSpec()})
+	if err != nil {
+		return false, fmt.Errorf("ImageStatus call failed: %w", err)
+	}
+	return resp.GetImage() != nil, nil
+}
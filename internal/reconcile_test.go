@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stackrox/image-prefetcher/internal/credentialprovider"
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+	"github.com/stackrox/image-prefetcher/internal/nodelabels"
+
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	criV1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+func TestReconcile(t *testing.T) {
+	client := &fakeImageService{images: map[string]*criV1.Image{
+		"present": {Id: "sha256:1"},
+	}}
+	f := &prefetcher{
+		logger:    slogt.New(t),
+		config:    Config{Timing: testTiming, PullPolicy: imagelist.PullAlways},
+		criClient: client,
+		kr:        &credentialprovider.BasicDockerKeyring{},
+	}
+	var status nodeStatus
+	status.results.Store(pullTarget{image: "present"}, nodelabels.OutcomePulled)
+	status.results.Store(pullTarget{image: "collected"}, nodelabels.OutcomePulled)
+	status.results.Store(pullTarget{image: "collected-if-not-present"}, nodelabels.OutcomeAlreadyPresent)
+	// Images pulled by digest are not recorded under their tags.
+	client.images["pinned@sha256:9"] = &criV1.Image{Id: "sha256:9"}
+	status.results.Store(pullTarget{image: "pinned"}, nodelabels.OutcomePulled)
+	status.storeResolvedDigests(map[string]string{"pinned": "sha256:9"})
+	queue := newImageQueue()
+
+	f.reconcile(t.Context(), []imagelist.Image{
+		{Name: "present"},
+		{Name: "pinned"},
+		{Name: "collected", Priority: 3},
+		{Name: "collected-if-not-present", PullPolicy: imagelist.PullIfNotPresent},
+		{Name: "other-platform", Platform: "plan9/mips"},
+	}, queue, &status)
+
+	missing := queue.take()
+	assert.Equal(t, []imagelist.Image{
+		{Name: "collected", Priority: 3},
+		{Name: "collected-if-not-present", PullPolicy: imagelist.PullIfNotPresent},
+	}, missing)
+	outcome, _ := status.results.Load(pullTarget{image: "collected"})
+	assert.Equal(t, nodelabels.OutcomeMissing, outcome)
+	outcome, _ = status.results.Load(pullTarget{image: "collected-if-not-present"})
+	assert.Equal(t, nodelabels.OutcomeMissing, outcome)
+	outcome, _ = status.results.Load(pullTarget{image: "present"})
+	assert.Equal(t, nodelabels.OutcomePulled, outcome)
+	outcome, _ = status.results.Load(pullTarget{image: "pinned"})
+	assert.Equal(t, nodelabels.OutcomePulled, outcome)
+
+	// An image pulled again between the check and planning is still pulled, rather than recorded as already present.
+	client.images["collected"] = &criV1.Image{Id: "sha256:2"}
+	plan := f.planJobs(t.Context(), missing[:1], &status.results, nil)
+	require.Len(t, plan.jobs, 1)
+	assert.Equal(t, pullTarget{image: "collected"}, plan.jobs[0].pullTarget)
+	outcome, _ = status.results.Load(pullTarget{image: "collected"})
+	assert.Equal(t, nodelabels.OutcomeMissing, outcome)
+
+	f.reconcile(t.Context(), []imagelist.Image{{Name: "present"}, {Name: "collected"}}, queue, &status)
+	assert.Empty(t, queue.take())
+}
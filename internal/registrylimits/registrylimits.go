@@ -0,0 +1,195 @@
+// Package registrylimits throttles image pulls per OCI registry host.
+package registrylimits
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"sigs.k8s.io/yaml"
+)
+
+const dockerHubHost = "docker.io"
+
+// Limits configures throttling of pulls from a single registry. Zero values mean no limit.
+type Limits struct {
+	// MaxParallelPulls is the maximum number of pull attempts in flight at once.
+	MaxParallelPulls int `json:"maxParallelPulls"`
+	// PullsPerMinute is the rate at which pull attempts may be started, enforced using a token bucket.
+	PullsPerMinute int `json:"pullsPerMinute"`
+}
+
+// Config holds limits for all registries.
+type Config struct {
+	// Default limits apply to registries not listed explicitly.
+	Default Limits `json:"default"`
+	// Registries maps registry host (for example "quay.io" or "localhost:5000") to its limits.
+	// An entry replaces the default limits entirely.
+	Registries map[string]Limits `json:"registries"`
+}
+
+// LoadConfigFile reads registry limits from a YAML or JSON file.
+// Limits from the file are merged on top of the given defaults.
+func LoadConfigFile(fileName string, defaults Limits) (Config, error) {
+	config := Config{Default: defaults}
+	if fileName == "" {
+		return config, nil
+	}
+	bytes, err := os.ReadFile(fileName)
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.UnmarshalStrict(bytes, &config); err != nil {
+		return config, fmt.Errorf("failed to parse registry limits file %q: %w", fileName, err)
+	}
+	normalized := make(map[string]Limits, len(config.Registries))
+	for host, limits := range config.Registries {
+		normalized[normalizeHost(host)] = limits
+	}
+	config.Registries = normalized
+	return config, nil
+}
+
+// Limiter hands out permissions to pull from registries, according to its Config.
+// A nil *Limiter does not throttle at all.
+type Limiter struct {
+	config     Config
+	mutex      sync.Mutex
+	registries map[string]*registry
+	// released is closed, and replaced, whenever a parallel pull slot of any registry is released.
+	released chan struct{}
+}
+
+type registry struct {
+	slots   chan struct{} // nil means unlimited
+	limiter *rate.Limiter // nil means unlimited
+}
+
+// NewLimiter creates a limiter using the given configuration.
+func NewLimiter(config Config) *Limiter {
+	return &Limiter{
+		config:     config,
+		registries: make(map[string]*registry),
+		released:   make(chan struct{}),
+	}
+}
+
+// Acquire blocks until a pull of the given image may start, or ctx is done.
+// On success, the caller must call the returned release function once the pull attempt is over.
+func (l *Limiter) Acquire(ctx context.Context, image string) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	r := l.registryFor(RegistryHost(image))
+	if r.slots != nil {
+		select {
+		case r.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	release = l.releaseFunc(r)
+	if r.limiter != nil {
+		if err := r.limiter.Wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+// TryAcquire is like Acquire, but does not wait. If a pull of the given image may not start right away, it returns
+// false, along with how long until the rate limit of the registry allows one, or zero if pulls in flight need to
+// finish first, which Released tells about.
+func (l *Limiter) TryAcquire(image string) (release func(), wait time.Duration, ok bool) {
+	if l == nil {
+		return func() {}, 0, true
+	}
+	r := l.registryFor(RegistryHost(image))
+	if r.slots != nil {
+		select {
+		case r.slots <- struct{}{}:
+		default:
+			return nil, 0, false
+		}
+	}
+	if r.limiter != nil {
+		reservation := r.limiter.Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			// Nobody waiting could make use of the slot before the delay is over, so don't tell them about it.
+			if r.slots != nil {
+				<-r.slots
+			}
+			return nil, delay, false
+		}
+	}
+	return l.releaseFunc(r), 0, true
+}
+
+// Released returns a channel which is closed once a parallel pull slot of any registry is released.
+// A nil *Limiter never releases slots, and returns a nil channel.
+func (l *Limiter) Released() <-chan struct{} {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.released
+}
+
+func (l *Limiter) releaseFunc(r *registry) func() {
+	return func() {
+		if r.slots == nil {
+			return
+		}
+		<-r.slots
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		close(l.released)
+		l.released = make(chan struct{})
+	}
+}
+
+func (l *Limiter) registryFor(host string) *registry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if r, ok := l.registries[host]; ok {
+		return r
+	}
+	limits, ok := l.config.Registries[host]
+	if !ok {
+		limits = l.config.Default
+	}
+	r := &registry{}
+	if limits.MaxParallelPulls > 0 {
+		r.slots = make(chan struct{}, limits.MaxParallelPulls)
+	}
+	if limits.PullsPerMinute > 0 {
+		r.limiter = rate.NewLimiter(rate.Limit(float64(limits.PullsPerMinute)/60), 1)
+	}
+	l.registries[host] = r
+	return r
+}
+
+// RegistryHost returns the registry host part of an image name, following the docker conventions
+// for names without an explicit registry.
+func RegistryHost(image string) string {
+	first, _, found := strings.Cut(image, "/")
+	if !found || (!strings.ContainsAny(first, ".:") && first != "localhost") {
+		return dockerHubHost
+	}
+	return normalizeHost(first)
+}
+
+func normalizeHost(host string) string {
+	switch host {
+	case "index.docker.io", "registry-1.docker.io":
+		return dockerHubHost
+	}
+	return host
+}
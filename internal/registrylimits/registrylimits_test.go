@@ -0,0 +1,154 @@
+package registrylimits
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryHost(t *testing.T) {
+	tests := map[string]string{
+		"nginx":                                 "docker.io",
+		"library/nginx:latest":                  "docker.io",
+		"docker.io/library/nginx":               "docker.io",
+		"index.docker.io/library/nginx":         "docker.io",
+		"quay.io/strimzi/kafka:latest":          "quay.io",
+		"localhost/foo":                         "localhost",
+		"localhost:5000/foo:bar":                "localhost:5000",
+		"registry.example.com:443/a/b/c@sha256": "registry.example.com:443",
+	}
+	for image, expected := range tests {
+		t.Run(image, func(t *testing.T) {
+			assert.Equal(t, expected, RegistryHost(image))
+		})
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	defaults := Limits{MaxParallelPulls: 3}
+	config, err := LoadConfigFile("", defaults)
+	require.NoError(t, err)
+	assert.Equal(t, Config{Default: defaults}, config)
+
+	fileName := filepath.Join(t.TempDir(), "limits.yaml")
+	require.NoError(t, os.WriteFile(fileName, []byte(`
+registries:
+  quay.io:
+    maxParallelPulls: 4
+    pullsPerMinute: 60
+  index.docker.io:
+    pullsPerMinute: 10
+`), 0o600))
+	config, err = LoadConfigFile(fileName, defaults)
+	require.NoError(t, err)
+	assert.Equal(t, Config{
+		Default: defaults,
+		Registries: map[string]Limits{
+			"quay.io":   {MaxParallelPulls: 4, PullsPerMinute: 60},
+			"docker.io": {PullsPerMinute: 10},
+		},
+	}, config)
+
+	require.NoError(t, os.WriteFile(fileName, []byte("registries:\n  quay.io:\n    bogus: 1\n"), 0o600))
+	_, err = LoadConfigFile(fileName, defaults)
+	assert.Error(t, err)
+}
+
+func TestLimiterParallelism(t *testing.T) {
+	l := NewLimiter(Config{
+		Default:    Limits{MaxParallelPulls: 1},
+		Registries: map[string]Limits{"quay.io": {MaxParallelPulls: 2}},
+	})
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+
+	release1, err := l.Acquire(ctx, "quay.io/a")
+	require.NoError(t, err)
+	_, err = l.Acquire(ctx, "quay.io/b")
+	require.NoError(t, err)
+	// Different registry is not affected.
+	releaseDocker, err := l.Acquire(ctx, "nginx")
+	require.NoError(t, err)
+
+	// Both registries are now full.
+	_, err = l.Acquire(ctx, "quay.io/c")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = l.Acquire(ctx, "docker.io/library/nginx")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release1()
+	releaseDocker()
+	release, err := l.Acquire(t.Context(), "quay.io/c")
+	require.NoError(t, err)
+	release()
+	release, err = l.Acquire(t.Context(), "nginx")
+	require.NoError(t, err)
+	release()
+}
+
+func TestLimiterRate(t *testing.T) {
+	l := NewLimiter(Config{Default: Limits{PullsPerMinute: 1}})
+	release, err := l.Acquire(t.Context(), "quay.io/a")
+	require.NoError(t, err)
+	release()
+
+	// The single token was used up, and the next one arrives in a minute.
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	_, err = l.Acquire(ctx, "quay.io/a")
+	assert.Error(t, err)
+
+	// Other registries have their own buckets.
+	release, err = l.Acquire(ctx, "ghcr.io/a")
+	require.NoError(t, err)
+	release()
+}
+
+func TestLimiterTryAcquire(t *testing.T) {
+	l := NewLimiter(Config{
+		Default:    Limits{MaxParallelPulls: 1},
+		Registries: map[string]Limits{"ghcr.io": {PullsPerMinute: 1}},
+	})
+	release, _, ok := l.TryAcquire("quay.io/a")
+	require.True(t, ok)
+	released := l.Released()
+
+	// The registry is full, but others are not.
+	_, wait, ok := l.TryAcquire("quay.io/b")
+	assert.False(t, ok)
+	assert.Zero(t, wait)
+	releaseDocker, _, ok := l.TryAcquire("nginx")
+	require.True(t, ok)
+
+	release()
+	select {
+	case <-released:
+	default:
+		t.Error("release not announced")
+	}
+	releaseDocker()
+
+	// The single token was used up, and the next one arrives in a minute.
+	release, _, ok = l.TryAcquire("ghcr.io/a")
+	require.True(t, ok)
+	release()
+	_, wait, ok = l.TryAcquire("ghcr.io/a")
+	assert.False(t, ok)
+	assert.Greater(t, wait, 50*time.Second)
+}
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+	release, err := l.Acquire(t.Context(), "quay.io/a")
+	require.NoError(t, err)
+	release()
+	release, _, ok := l.TryAcquire("quay.io/a")
+	require.True(t, ok)
+	release()
+	assert.Nil(t, l.Released())
+}
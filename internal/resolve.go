@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"context"
+	"time"
+
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+	metricsProto "github.com/stackrox/image-prefetcher/internal/metrics/gen"
+)
+
+const tagResolutionTimeout = time.Minute
+
+// resolveTags asks the metrics endpoint for the digests of the tags of images which are not pinned to a digest yet,
+// if tag resolution is enabled. Failure is not fatal, it just means images are pulled by tag.
+func (f *prefetcher) resolveTags(ctx context.Context, images []imagelist.Image) map[string]string {
+	if !f.config.ResolveTags || f.metricsClient == nil {
+		return nil
+	}
+	var names []string
+	for _, image := range images {
+		if image.ExpectedDigest == "" {
+			names = append(names, image.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, tagResolutionTimeout)
+	defer cancel()
+	// Dry runs must not make the aggregator cache digests, which all nodes would then be pinned to.
+	resolved, err := f.metricsClient.ResolveTags(ctx, &metricsProto.TagResolutionRequest{Images: names, CachedOnly: f.dryRun})
+	if err != nil {
+		f.logger.WarnContext(ctx, "failed to resolve image tags, pulling by tag", "error", err)
+		return nil
+	}
+	digests := resolved.GetDigests()
+	if len(digests) < len(names) {
+		f.logger.WarnContext(ctx, "some image tags could not be resolved, pulling them by tag", "resolved", len(digests), "images", len(names))
+	} else {
+		f.logger.InfoContext(ctx, "resolved image tags", "count", len(digests))
+	}
+	return digests
+}
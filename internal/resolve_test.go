@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stackrox/image-prefetcher/internal/credentialprovider"
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+	metricsProto "github.com/stackrox/image-prefetcher/internal/metrics/gen"
+
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+// fakeMetricsClient resolves tags from a fixed map, and records filesystem usage reports.
+// Nothing counts as resolved earlier, for requests of cached digests only. Other methods are not implemented.
+type fakeMetricsClient struct {
+	metricsProto.MetricsClient
+	digests     map[string]string
+	err         error
+	requested   []string
+	cachedOnly  bool
+	filesystems []*metricsProto.FilesystemUsage
+}
+
+func (f *fakeMetricsClient) ReportFilesystemUsage(_ context.Context, in *metricsProto.FilesystemUsage, _ ...grpc.CallOption) (*metricsProto.Empty, error) {
+	f.filesystems = append(f.filesystems, in)
+	return &metricsProto.Empty{}, nil
+}
+
+func (f *fakeMetricsClient) ResolveTags(_ context.Context, in *metricsProto.TagResolutionRequest, _ ...grpc.CallOption) (*metricsProto.ResolvedDigests, error) {
+	f.requested = append(f.requested, in.GetImages()...)
+	f.cachedOnly = in.GetCachedOnly()
+	if f.err != nil {
+		return nil, f.err
+	}
+	resolved := &metricsProto.ResolvedDigests{Digests: make(map[string]string)}
+	if in.GetCachedOnly() {
+		return resolved, nil
+	}
+	for _, image := range in.GetImages() {
+		if digest, ok := f.digests[image]; ok {
+			resolved.Digests[image] = digest
+		}
+	}
+	return resolved, nil
+}
+
+func TestResolveTags(t *testing.T) {
+	const (
+		resolvedDigest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		listedDigest   = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	)
+	images := []imagelist.Image{
+		{Name: "quay.io/example/app:v1"},
+		{Name: "quay.io/example/unknown:v1"},
+		{Name: "quay.io/example/pinned:v1", ExpectedDigest: listedDigest},
+	}
+	tests := map[string]struct {
+		resolveTags        bool
+		dryRun             bool
+		err                error
+		expectedRequested  []string
+		expectedCachedOnly bool
+		expectedDigests    []string
+		// expectedPulled are the references images are pulled as, if not by their listed tags.
+		expectedPulled []string
+	}{
+		"disabled": {
+			expectedDigests: []string{"", "", listedDigest},
+		},
+		"resolved": {
+			resolveTags:       true,
+			expectedRequested: []string{"quay.io/example/app:v1", "quay.io/example/unknown:v1"},
+			expectedDigests:   []string{resolvedDigest, "", listedDigest},
+			expectedPulled:    []string{"quay.io/example/app@" + resolvedDigest, "quay.io/example/unknown:v1", "quay.io/example/pinned:v1"},
+		},
+		"dry run": {
+			resolveTags:        true,
+			dryRun:             true,
+			expectedRequested:  []string{"quay.io/example/app:v1", "quay.io/example/unknown:v1"},
+			expectedCachedOnly: true,
+			expectedDigests:    []string{"", "", listedDigest},
+		},
+		"resolution failed": {
+			resolveTags:       true,
+			err:               errors.New("unavailable"),
+			expectedRequested: []string{"quay.io/example/app:v1", "quay.io/example/unknown:v1"},
+			expectedDigests:   []string{"", "", listedDigest},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			metricsClient := &fakeMetricsClient{digests: map[string]string{"quay.io/example/app:v1": resolvedDigest}, err: test.err}
+			f := &prefetcher{
+				logger:        slogt.New(t),
+				config:        Config{Timing: testTiming, PullPolicy: imagelist.PullAlways, ResolveTags: test.resolveTags},
+				criClient:     &fakeImageService{},
+				kr:            &credentialprovider.BasicDockerKeyring{},
+				metricsClient: metricsClient,
+				dryRun:        test.dryRun,
+			}
+			var results sync.Map
+			plan := f.planJobs(t.Context(), images, &results, nil)
+
+			assert.Equal(t, test.expectedRequested, metricsClient.requested)
+			assert.Equal(t, test.expectedCachedOnly, metricsClient.cachedOnly)
+			var digests, pulled, listed []string
+			for _, job := range plan.jobs {
+				digests = append(digests, job.expectedDigest)
+				pulled = append(pulled, job.imageSpec().Image)
+				listed = append(listed, job.image)
+			}
+			assert.Equal(t, test.expectedDigests, digests)
+			if test.expectedPulled == nil {
+				test.expectedPulled = listed
+			}
+			assert.Equal(t, test.expectedPulled, pulled)
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+	metricsProto "github.com/stackrox/image-prefetcher/internal/metrics/gen"
+	"github.com/stackrox/image-prefetcher/internal/pullerrors"
+
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	criV1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// hangingImageServer is a CRI image service whose pulls never finish before the caller gives up.
+type hangingImageServer struct {
+	criV1.UnimplementedImageServiceServer
+}
+
+func (hangingImageServer) ListImages(context.Context, *criV1.ListImagesRequest) (*criV1.ListImagesResponse, error) {
+	return &criV1.ListImagesResponse{}, nil
+}
+
+func (hangingImageServer) PullImage(ctx context.Context, _ *criV1.PullImageRequest) (*criV1.PullImageResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+type collectingMetricsServer struct {
+	metricsProto.UnimplementedMetricsServer
+	mutex   sync.Mutex
+	results []*metricsProto.Result
+}
+
+func (s *collectingMetricsServer) Submit(stream metricsProto.Metrics_SubmitServer) error {
+	for {
+		result, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&metricsProto.Empty{})
+		}
+		if err != nil {
+			return err
+		}
+		s.mutex.Lock()
+		s.results = append(s.results, result)
+		s.mutex.Unlock()
+	}
+}
+
+func serve(t *testing.T, network, address string, register func(*grpc.Server)) net.Addr {
+	listener, err := net.Listen(network, address)
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	register(server)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+	return listener.Addr()
+}
+
+func TestRunReportsAfterPullTimeout(t *testing.T) {
+	criSocket := filepath.Join(t.TempDir(), "cri.sock")
+	serve(t, "unix", criSocket, func(s *grpc.Server) { criV1.RegisterImageServiceServer(s, hangingImageServer{}) })
+	metrics := &collectingMetricsServer{}
+	metricsAddr := serve(t, "tcp", "127.0.0.1:0", func(s *grpc.Server) { metricsProto.RegisterMetricsServer(s, metrics) })
+
+	timing := testTiming
+	timing.InitialPullAttemptTimeout = time.Minute
+	timing.MaxPullAttemptTimeout = time.Minute
+	timing.OverallTimeout = 200 * time.Millisecond
+	timing.ReportingTimeout = 10 * time.Second
+	err := Run(slogt.New(t), Config{
+		CRISocketPath:   criSocket,
+		MetricsEndpoint: metricsAddr.String(),
+		Timing:          timing,
+	}, imagelist.FromNames("nginx")...)
+	require.NoError(t, err)
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	require.Len(t, metrics.results, 1, "metrics must be submitted even though pulls ran out of time")
+	assert.Equal(t, "docker.io/library/nginx:latest", metrics.results[0].Image)
+	assert.Equal(t, string(pullerrors.ClassDeadline), metrics.results[0].ErrorClass)
+}
@@ -0,0 +1,318 @@
+// Package tagresolver resolves image tags to manifest digests using the registry HTTP API, so that all nodes can pull
+// the same digest of a tag, even if the tag is moved while they pull.
+package tagresolver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stackrox/image-prefetcher/internal/credentialprovider"
+)
+
+// manifestMediaTypes are accepted for manifests, so that registries return the digest of the index of a multi-platform
+// image, rather than converting it to a single-platform manifest. This is the digest runtimes record for the image.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+const (
+	dockerHubDomain      = "docker.io"
+	dockerHubRegistryAPI = "registry-1.docker.io"
+	digestHeader         = "Docker-Content-Digest"
+)
+
+// lookupTimeout bounds a single resolution of an image, which may take several registry requests.
+// It is independent of callers, since callers resolving the same image concurrently share the resolution.
+const lookupTimeout = 2 * time.Minute
+
+// Resolver resolves image tags to manifest digests. Results are cached for a TTL, during which later resolutions of
+// an image return the same digest, so that all clients rolling out at about the same time are told the same one.
+// Concurrent resolutions of an image which is not cached share a single registry lookup.
+type Resolver struct {
+	logger   *slog.Logger
+	client   *http.Client
+	keyring  credentialprovider.DockerKeyring
+	ttl      time.Duration
+	now      func() time.Time
+	mutex    sync.Mutex
+	cache    map[string]cachedDigest
+	inFlight map[string]*lookup
+}
+
+// cachedDigest is a resolved digest, valid until it expires.
+type cachedDigest struct {
+	digest  string
+	expires time.Time
+}
+
+// lookup is a registry lookup in progress, which all concurrent resolutions of the image wait for.
+type lookup struct {
+	done   chan struct{}
+	digest string
+	err    error
+}
+
+// New creates a resolver which uses the given HTTP client, and credentials from the keyring for registries which
+// require them. Resolved digests are cached for ttl. Zero ttl disables caching.
+func New(logger *slog.Logger, client *http.Client, keyring credentialprovider.DockerKeyring, ttl time.Duration) *Resolver {
+	return &Resolver{
+		logger:   logger,
+		client:   client,
+		keyring:  keyring,
+		ttl:      ttl,
+		now:      time.Now,
+		cache:    make(map[string]cachedDigest),
+		inFlight: make(map[string]*lookup),
+	}
+}
+
+// Resolve returns the manifest digest of the image, which must be a normalized name with a tag,
+// such as docker.io/library/nginx:latest. Names which already include a digest resolve to it.
+func (r *Resolver) Resolve(ctx context.Context, image string) (string, error) {
+	if _, digest, found := strings.Cut(image, "@"); found {
+		return digest, nil
+	}
+	r.mutex.Lock()
+	if digest, ok := r.cachedLocked(image); ok {
+		r.mutex.Unlock()
+		return digest, nil
+	}
+	l, ok := r.inFlight[image]
+	if !ok {
+		l = &lookup{done: make(chan struct{})}
+		r.inFlight[image] = l
+		go r.lookup(image, l)
+	}
+	r.mutex.Unlock()
+	select {
+	case <-l.done:
+		return l.digest, l.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Cached returns the digest of the image if it was resolved and has not expired yet, without asking its registry.
+func (r *Resolver) Cached(image string) (string, bool) {
+	if _, digest, found := strings.Cut(image, "@"); found {
+		return digest, true
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.cachedLocked(image)
+}
+
+func (r *Resolver) cachedLocked(image string) (string, bool) {
+	cached, ok := r.cache[image]
+	if !ok || !r.now().Before(cached.expires) {
+		return "", false
+	}
+	return cached.digest, true
+}
+
+func (r *Resolver) lookup(image string, l *lookup) {
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	digest, err := r.resolve(ctx, image)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.inFlight, image)
+	if err != nil {
+		l.err = fmt.Errorf("failed to resolve %s: %w", image, err)
+	} else {
+		l.digest = digest
+		r.cache[image] = cachedDigest{digest: digest, expires: r.now().Add(r.ttl)}
+		r.logger.InfoContext(ctx, "resolved image tag", "image", image, "digest", digest)
+	}
+	close(l.done)
+}
+
+func (r *Resolver) resolve(ctx context.Context, image string) (string, error) {
+	host, repository, tag, err := splitImage(image)
+	if err != nil {
+		return "", err
+	}
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", host, repository, tag)
+	credentials, _ := r.keyring.Lookup(image)
+	// Try anonymous access after any credentials, since some registries reject credentials they do not know.
+	credentials = append(credentials, credentialprovider.AuthConfig{})
+	var errs []error
+	for _, creds := range credentials {
+		digest, err := r.headManifest(ctx, manifestURL, repository, creds)
+		if err == nil {
+			return digest, nil
+		}
+		errs = append(errs, err)
+		if !errors.Is(err, errUnauthorized) {
+			break
+		}
+	}
+	return "", errors.Join(errs...)
+}
+
+var errUnauthorized = errors.New("unauthorized")
+
+// headManifest asks the registry for the digest of a manifest. If the registry challenges for authentication,
+// it authenticates with the given credentials, which may be empty for anonymous access, and tries again.
+func (r *Resolver) headManifest(ctx context.Context, manifestURL, repository string, creds credentialprovider.AuthConfig) (string, error) {
+	resp, err := r.do(ctx, http.MethodHead, manifestURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		authorize, err := r.authorizer(ctx, resp.Header.Get("WWW-Authenticate"), repository, creds)
+		if err != nil {
+			return "", err
+		}
+		if resp, err = r.do(ctx, http.MethodHead, manifestURL, authorize); err != nil {
+			return "", err
+		}
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return "", fmt.Errorf("%s: %w", resp.Status, errUnauthorized)
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("unexpected response from %s: %s", manifestURL, resp.Status)
+	}
+	digest := resp.Header.Get(digestHeader)
+	if digest == "" {
+		return "", fmt.Errorf("registry did not return a %s header for %s", digestHeader, manifestURL)
+	}
+	return digest, nil
+}
+
+func (r *Resolver) do(ctx context.Context, method, url string, authorize func(*http.Request)) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorize != nil {
+		authorize(req)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	return resp, nil
+}
+
+// authorizer returns a function authorizing requests as demanded by the challenge in a WWW-Authenticate header:
+// either with basic authentication, or with a bearer token obtained from the token service named by the challenge.
+func (r *Resolver) authorizer(ctx context.Context, challenge string, repository string, creds credentialprovider.AuthConfig) (func(*http.Request), error) {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if creds.Username == "" {
+			return nil, fmt.Errorf("registry requires basic authentication, but no credentials are available: %w", errUnauthorized)
+		}
+		return func(req *http.Request) { req.SetBasicAuth(creds.Username, creds.Password) }, nil
+	case "bearer":
+		token, err := r.fetchToken(ctx, params, repository, creds)
+		if err != nil {
+			return nil, err
+		}
+		return func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }, nil
+	}
+	return nil, fmt.Errorf("unsupported authentication challenge %q", challenge)
+}
+
+func (r *Resolver) fetchToken(ctx context.Context, params map[string]string, repository string, creds credentialprovider.AuthConfig) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid token realm %q in authentication challenge", params["realm"])
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", "repository:"+repository+":pull")
+	realm.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if creds.Username != "" {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return "", fmt.Errorf("token request: %s: %w", resp.Status, errUnauthorized)
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("unexpected response to token request: %s", resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", errors.New("token response contains no token")
+}
+
+// parseChallenge parses a WWW-Authenticate header with a single challenge, such as
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io".
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := make(map[string]string)
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		key, value, found := strings.Cut(rest, "=")
+		if !found {
+			break
+		}
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				break
+			}
+			params[strings.ToLower(strings.TrimSpace(key))] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			value, rest, _ = strings.Cut(value, ",")
+			params[strings.ToLower(strings.TrimSpace(key))] = value
+			rest = "," + rest
+		}
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+	}
+	return scheme, params
+}
+
+// splitImage splits a normalized image name into the host serving the registry API, the repository and the tag.
+func splitImage(image string) (string, string, string, error) {
+	domain, path, found := strings.Cut(image, "/")
+	if !found {
+		return "", "", "", fmt.Errorf("image name %q is not normalized", image)
+	}
+	i := strings.LastIndex(path, ":")
+	if i < 0 || strings.Contains(path[i:], "/") {
+		return "", "", "", fmt.Errorf("image name %q has no tag", image)
+	}
+	if domain == dockerHubDomain {
+		domain = dockerHubRegistryAPI
+	}
+	return domain, path[:i], path[i+1:], nil
+}
@@ -0,0 +1,255 @@
+package tagresolver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stackrox/image-prefetcher/internal/credentialprovider"
+
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	digest1 = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	digest2 = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+// fakeRegistry is a stand-in for a registry with manifests keyed by repository:tag. Repositories starting with
+// private/ require a bearer token from its token service, which requires basic authentication as user:pass.
+// Repositories starting with basic/ require basic authentication directly.
+// If gate is set, manifest requests wait until it is closed.
+type fakeRegistry struct {
+	mutex     sync.Mutex
+	manifests map[string]string
+	requests  int
+	gate      chan struct{}
+	arrived   atomic.Int32
+}
+
+func (f *fakeRegistry) setManifest(repositoryTag, digest string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.manifests[repositoryTag] = digest
+}
+
+func (f *fakeRegistry) requestCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.requests
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.gate != nil && r.URL.Path != "/token" {
+		f.arrived.Add(1)
+		<-f.gate
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if r.URL.Path == "/token" {
+		if user, pass, _ := r.BasicAuth(); user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"token": "token-for-%s"}`, r.URL.Query().Get("scope"))
+		return
+	}
+	path, found := strings.CutPrefix(r.URL.Path, "/v2/")
+	repository, tag, _ := strings.Cut(path, "/manifests/")
+	if !found || r.Method != http.MethodHead || !strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.requests++
+	switch {
+	case strings.HasPrefix(repository, "private/"):
+		if r.Header.Get("Authorization") != "Bearer token-for-repository:"+repository+":pull" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="https://%s/token",service="fake"`, r.Host))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	case strings.HasPrefix(repository, "basic/"):
+		if user, pass, _ := r.BasicAuth(); user != "user" || pass != "pass" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	digest, ok := f.manifests[repository+":"+tag]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set(digestHeader, digest)
+}
+
+func TestResolve(t *testing.T) {
+	registry := &fakeRegistry{manifests: map[string]string{
+		"public/app:v1":  digest1,
+		"private/app:v1": digest1,
+		"basic/app:v1":   digest1,
+	}}
+	server := httptest.NewTLSServer(registry)
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	tests := map[string]struct {
+		image       string
+		credentials credentialprovider.DockerConfig
+		expected    string
+		expectedErr string
+	}{
+		"anonymous": {
+			image:    host + "/public/app:v1",
+			expected: digest1,
+		},
+		"bearer token": {
+			image:       host + "/private/app:v1",
+			credentials: credentialprovider.DockerConfig{host: {Username: "user", Password: "pass"}},
+			expected:    digest1,
+		},
+		"rejected credentials": {
+			image:       host + "/private/app:v1",
+			credentials: credentialprovider.DockerConfig{host: {Username: "user", Password: "wrong"}},
+			expectedErr: "unauthorized",
+		},
+		"basic authentication": {
+			image:       host + "/basic/app:v1",
+			credentials: credentialprovider.DockerConfig{host: {Username: "user", Password: "pass"}},
+			expected:    digest1,
+		},
+		"basic authentication without credentials": {
+			image:       host + "/basic/app:v1",
+			expectedErr: "no credentials are available",
+		},
+		"unknown tag": {
+			image:       host + "/public/app:v2",
+			expectedErr: "404 Not Found",
+		},
+		"digest reference": {
+			image:    host + "/public/app:v2@" + digest2,
+			expected: digest2,
+		},
+		"not normalized": {
+			image:       "app:v1",
+			expectedErr: "not normalized",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			keyring := &credentialprovider.BasicDockerKeyring{}
+			keyring.Add(test.credentials)
+			resolver := New(slogt.New(t), server.Client(), keyring, time.Minute)
+
+			digest, err := resolver.Resolve(t.Context(), test.image)
+
+			if test.expectedErr != "" {
+				assert.ErrorContains(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, digest)
+		})
+	}
+}
+
+func TestResolveCached(t *testing.T) {
+	registry := &fakeRegistry{manifests: map[string]string{"public/app:latest": digest1}}
+	server := httptest.NewTLSServer(registry)
+	t.Cleanup(server.Close)
+	image := strings.TrimPrefix(server.URL, "https://") + "/public/app:latest"
+	resolver := New(slogt.New(t), server.Client(), &credentialprovider.BasicDockerKeyring{}, time.Minute)
+	now := time.Now()
+	resolver.now = func() time.Time { return now }
+
+	_, cached := resolver.Cached(image)
+	assert.False(t, cached)
+	digest, err := resolver.Resolve(t.Context(), image)
+	require.NoError(t, err)
+	assert.Equal(t, digest1, digest)
+
+	registry.setManifest("public/app:latest", digest2)
+	digest, err = resolver.Resolve(t.Context(), image)
+	require.NoError(t, err)
+	assert.Equal(t, digest1, digest, "moved tag must resolve to the cached digest until it expires")
+	digest, cached = resolver.Cached(image)
+	assert.True(t, cached)
+	assert.Equal(t, digest1, digest)
+	assert.Equal(t, 1, registry.requestCount())
+
+	now = now.Add(time.Minute)
+	_, cached = resolver.Cached(image)
+	assert.False(t, cached)
+	digest, err = resolver.Resolve(t.Context(), image)
+	require.NoError(t, err)
+	assert.Equal(t, digest2, digest, "moved tag must be resolved again once the cached digest expired")
+	assert.Equal(t, 2, registry.requestCount())
+}
+
+func TestResolveConcurrently(t *testing.T) {
+	registry := &fakeRegistry{manifests: map[string]string{"public/app:latest": digest1}, gate: make(chan struct{})}
+	server := httptest.NewTLSServer(registry)
+	t.Cleanup(server.Close)
+	image := strings.TrimPrefix(server.URL, "https://") + "/public/app:latest"
+	resolver := New(slogt.New(t), server.Client(), &credentialprovider.BasicDockerKeyring{}, time.Minute)
+
+	const clients = 20
+	digests := make(chan string, clients)
+	var wg sync.WaitGroup
+	for range clients {
+		wg.Go(func() {
+			digest, err := resolver.Resolve(t.Context(), image)
+			assert.NoError(t, err)
+			digests <- digest
+		})
+	}
+	require.Eventually(t, func() bool { return registry.arrived.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+	// A client giving up must not affect the others waiting for the same lookup.
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	_, err := resolver.Resolve(ctx, image)
+	assert.ErrorIs(t, err, context.Canceled)
+	close(registry.gate)
+	wg.Wait()
+	close(digests)
+
+	for digest := range digests {
+		assert.Equal(t, digest1, digest)
+	}
+	assert.Equal(t, 1, registry.requestCount())
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io", scope="repository:library/nginx:pull,push"`)
+	assert.Equal(t, "Bearer", scheme)
+	assert.Equal(t, map[string]string{
+		"realm":   "https://auth.docker.io/token",
+		"service": "registry.docker.io",
+		"scope":   "repository:library/nginx:pull,push",
+	}, params)
+
+	scheme, params = parseChallenge(`Basic realm=registry`)
+	assert.Equal(t, "Basic", scheme)
+	assert.Equal(t, map[string]string{"realm": "registry"}, params)
+}
+
+func TestSplitImage(t *testing.T) {
+	host, repository, tag, err := splitImage("docker.io/library/nginx:latest")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"registry-1.docker.io", "library/nginx", "latest"}, []string{host, repository, tag})
+
+	host, repository, tag, err = splitImage("registry.local:5000/team/app:v1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"registry.local:5000", "team/app", "v1"}, []string{host, repository, tag})
+
+	_, _, _, err = splitImage("registry.local:5000/team/app")
+	assert.ErrorContains(t, err, "has no tag")
+}
@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// errTerminated is the cancellation cause of pulls interrupted by a termination signal.
+var errTerminated = errors.New("terminated by signal")
+
+// cancelOnTermination cancels pulls with errTerminated once SIGTERM or SIGINT is received,
+// and cancels everything else after the grace period, which is left for reporting the results so far.
+// Returns a function which stops listening for signals.
+func cancelOnTermination(logger *slog.Logger, cancelPulls context.CancelCauseFunc, cancelAll context.CancelFunc, gracePeriod time.Duration) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case s := <-signals:
+			logger.Warn("received termination signal, interrupting pulls", "signal", s, "gracePeriod", gracePeriod)
+			cancelPulls(fmt.Errorf("%w %s", errTerminated, s))
+			time.AfterFunc(gracePeriod, cancelAll)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// interrupted returns whether ctx was cancelled because of a termination signal.
+func interrupted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errTerminated)
+}
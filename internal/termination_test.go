@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stackrox/image-prefetcher/internal/nodelabels"
+
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	criV1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+func TestCancelOnTermination(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	pullCtx, cancelPulls := context.WithCancelCause(ctx)
+	defer cancelPulls(nil)
+	stop := cancelOnTermination(slogt.New(t), cancelPulls, cancel, 50*time.Millisecond)
+	defer stop()
+
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGTERM))
+	select {
+	case <-pullCtx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("pulls not cancelled after signal")
+	}
+	assert.True(t, interrupted(pullCtx))
+	assert.NoError(t, ctx.Err(), "reporting must not be cancelled before the grace period")
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("reporting not cancelled after grace period")
+	}
+}
+
+func TestCancelOnTerminationStop(t *testing.T) {
+	pullCtx, cancelPulls := context.WithCancelCause(t.Context())
+	defer cancelPulls(nil)
+	cancelOnTermination(slogt.New(t), cancelPulls, func() {}, time.Second)()
+	assert.False(t, interrupted(pullCtx))
+}
+
+func TestPullImageInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(t.Context())
+	cancel(errTerminated)
+	var results, failures sync.Map
+	p := &puller{client: &fakeImageService{images: map[string]*criV1.Image{}}, timing: testTiming, results: &results, failures: &failures}
+	target := pullTarget{image: "nginx"}
+	p.pullImage(ctx, &pullJob{
+		pullTarget:  target,
+		logger:      slogt.New(t),
+		credentials: []credential{{source: credentialSourceAnonymous}},
+	}, 0)
+
+	outcome, _ := results.Load(target)
+	assert.Equal(t, nodelabels.OutcomeInterrupted, outcome)
+	_, failed := failures.Load(target)
+	assert.False(t, failed)
+}
@@ -0,0 +1,290 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+	"github.com/stackrox/image-prefetcher/internal/kube"
+	"github.com/stackrox/image-prefetcher/internal/nodelabels"
+	"github.com/stackrox/image-prefetcher/internal/workloads"
+)
+
+// WatchConfig configures which sources Watch takes new images from.
+type WatchConfig struct {
+	// Workloads enables pulling images which start being used by Deployments, StatefulSets, DaemonSets and CronJobs,
+	// ahead of their rollout.
+	Workloads bool
+	// Namespaces to watch workloads in. Empty means all namespaces.
+	Namespaces []string
+	// LabelSelector restricts which workloads are watched.
+	LabelSelector string
+	// ImageListFile, if set, is polled for images added to it, such as when the ConfigMap it is mounted from changes.
+	ImageListFile string
+	// ImageListPollInterval is how often ImageListFile is checked for changes.
+	ImageListPollInterval time.Duration
+	// ReconcileInterval is how often images in ImageListFile are checked for presence, and pulled again if missing.
+	// Zero disables these checks.
+	ReconcileInterval time.Duration
+	// BatchDelay is how long to wait for more new images before pulling them together.
+	BatchDelay time.Duration
+}
+
+// Watch pulls images as they appear in the configured sources, until SIGTERM or SIGINT is received.
+// Each batch of new images gets the overall and reporting timeouts of a Run, and updates the node label
+// set by Run, taking the outcome of earlier batches into account.
+// Images listed or in use when Watch starts are expected to be pulled by Run instead.
+func Watch(logger *slog.Logger, config Config, watchConfig WatchConfig) error {
+	reportCtx, cancelReport := context.WithCancel(context.Background())
+	defer cancelReport()
+	watchCtx, cancelWatch := context.WithCancelCause(context.Background())
+	defer cancelWatch(nil)
+	defer cancelOnTermination(logger, cancelWatch, cancelReport, config.Timing.TerminationGracePeriod)()
+
+	f, err := newPrefetcher(logger, config)
+	if err != nil {
+		return err
+	}
+	// Outcomes of pulls so far, combined with the status recorded by Run, determine the node label.
+	status := &nodeStatus{}
+	if status.earlier, err = nodelabels.GetStatus(watchCtx); err != nil {
+		logger.Info("could not read node label status recorded earlier", "error", err)
+	}
+	if config.ResolveTags {
+		resolved, err := nodelabels.GetResolvedDigests(watchCtx)
+		if err != nil {
+			logger.Info("could not read digests resolved earlier", "error", err)
+		}
+		status.storeResolvedDigests(resolved)
+	}
+	queue := newImageQueue()
+	if watchConfig.ImageListFile != "" {
+		listWatcher := &imageListWatcher{fileName: watchConfig.ImageListFile}
+		if _, err := listWatcher.poll(); err != nil {
+			return err
+		}
+		reconcile := func(ctx context.Context, images []imagelist.Image) { f.reconcile(ctx, images, queue, status) }
+		go listWatcher.run(watchCtx, logger, watchConfig.ImageListPollInterval, watchConfig.ReconcileInterval, queue, reconcile)
+		logger.Info("watching image list", "file", watchConfig.ImageListFile, "reconcileInterval", watchConfig.ReconcileInterval)
+	}
+	if watchConfig.Workloads {
+		client, err := kube.NewClientset()
+		if err != nil {
+			return fmt.Errorf("failed to create Kubernetes client for watching workloads: %w", err)
+		}
+		watcher := workloads.NewWatcher(client, watchConfig.Namespaces, watchConfig.LabelSelector, func(images []string) {
+			logger.Info("new workload images", "images", images)
+			queue.add(imagelist.FromNames(images...)...)
+		})
+		if err := watcher.Start(watchCtx); err != nil {
+			return fmt.Errorf("failed to start watching workloads: %w", err)
+		}
+		logger.Info("watching workloads", "namespaces", watchConfig.Namespaces, "labelSelector", watchConfig.LabelSelector)
+	}
+	f.pullQueued(watchCtx, reportCtx, queue, watchConfig.BatchDelay, status)
+	logger.Info("stopped watching", "cause", context.Cause(watchCtx))
+	return nil
+}
+
+// imageQueue collects images to pull, until they are taken in a batch.
+type imageQueue struct {
+	mu      sync.Mutex
+	pending []imagelist.Image
+	// ready has a value whenever pending is not empty.
+	ready chan struct{}
+}
+
+func newImageQueue() *imageQueue {
+	return &imageQueue{ready: make(chan struct{}, 1)}
+}
+
+// add adds images to the queue, unless an image of the same name is already there.
+func (q *imageQueue) add(images ...imagelist.Image) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, image := range images {
+		if !slices.ContainsFunc(q.pending, func(pending imagelist.Image) bool { return pending.Name == image.Name }) {
+			q.pending = append(q.pending, image)
+		}
+	}
+	if len(q.pending) > 0 {
+		select {
+		case q.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (q *imageQueue) take() []imagelist.Image {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	images := q.pending
+	q.pending = nil
+	return images
+}
+
+// imageListWatcher notices images added to an image list file.
+type imageListWatcher struct {
+	fileName string
+	content  []byte
+	// images are those in the file as of the last successful poll, and listed holds their names.
+	images []imagelist.Image
+	listed map[string]struct{}
+}
+
+// poll reads the file and returns images which were not in it the last time it was read successfully.
+// All images are new on the first poll.
+func (w *imageListWatcher) poll() ([]imagelist.Image, error) {
+	content, err := os.ReadFile(w.fileName)
+	if err != nil {
+		return nil, err
+	}
+	if w.listed != nil && bytes.Equal(content, w.content) {
+		return nil, nil
+	}
+	images, err := imagelist.Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", w.fileName, err)
+	}
+	listed := make(map[string]struct{}, len(images))
+	var added []imagelist.Image
+	for _, image := range images {
+		if _, ok := w.listed[image.Name]; !ok {
+			added = append(added, image)
+		}
+		listed[image.Name] = struct{}{}
+	}
+	w.content, w.images, w.listed = content, images, listed
+	return added, nil
+}
+
+// run polls the file at the given interval and adds new images to the queue, until ctx is done.
+// The file may be invalid for a while, such as when it is being edited, so errors are only logged.
+// Unless reconcileInterval is zero, images in the file are also passed to reconcile at that interval.
+func (w *imageListWatcher) run(ctx context.Context, logger *slog.Logger, interval time.Duration, reconcileInterval time.Duration,
+	queue *imageQueue, reconcile func(ctx context.Context, images []imagelist.Image)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var reconcileTicks <-chan time.Time
+	if reconcileInterval > 0 {
+		reconcileTicker := time.NewTicker(reconcileInterval)
+		defer reconcileTicker.Stop()
+		reconcileTicks = reconcileTicker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reconcileTicks:
+			reconcile(ctx, w.images)
+			continue
+		case <-ticker.C:
+		}
+		added, err := w.poll()
+		if err != nil {
+			logger.ErrorContext(ctx, "failed to read image list, keeping the previous one", "error", err)
+			continue
+		}
+		if len(added) > 0 {
+			logger.InfoContext(ctx, "new images in image list", "count", len(added))
+			queue.add(added...)
+		}
+	}
+}
+
+// nodeStatus determines the node label while watching.
+type nodeStatus struct {
+	// earlier is the status recorded before watching started.
+	earlier nodelabels.Status
+	// results holds the latest outcome for each image pulled or checked since.
+	results sync.Map // map[pullTarget]nodelabels.Outcome
+	// resolvedDigests holds the digest each image tag was last pinned to, since images pulled by digest
+	// are not recorded under their tags by the runtime.
+	resolvedDigests sync.Map // map[string]string
+}
+
+func (s *nodeStatus) storeResolvedDigests(digests map[string]string) {
+	for image, digest := range digests {
+		s.resolvedDigests.Store(image, digest)
+	}
+}
+
+// resolvedDigest returns the digest the tag of the image was last pinned to, or empty if it was not.
+func (s *nodeStatus) resolvedDigest(image string) string {
+	digest, _ := s.resolvedDigests.Load(image)
+	digestString, _ := digest.(string)
+	return digestString
+}
+
+func (s *nodeStatus) patchNodeLabels(ctx context.Context, logger *slog.Logger) {
+	// Don't stop watching if node labeling fails.
+	if err := nodelabels.PatchNodeLabelsSince(ctx, s.earlier, &s.results, logger); err != nil {
+		logger.Error("failed to update node labels", "error", err)
+	}
+}
+
+// pullQueued pulls images added to the queue in batches, until ctx is done.
+func (f *prefetcher) pullQueued(ctx context.Context, reportCtx context.Context, queue *imageQueue, batchDelay time.Duration, status *nodeStatus) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-queue.ready:
+		}
+		// Images of a single change, such as a rollout of several workloads, tend to show up in quick succession.
+		if err := sleepContext(ctx, batchDelay); err != nil {
+			return
+		}
+		if images := queue.take(); len(images) > 0 {
+			f.pullBatch(ctx, reportCtx, images, status)
+		}
+	}
+}
+
+// pullBatch pulls images once, within the overall timeout, recording outcomes in status.
+// Then, within the reporting timeout, it submits metrics about them and labels the node according to status.
+// Reporting is only cut short by cancellation of reportCtx, not of ctx.
+func (f *prefetcher) pullBatch(ctx context.Context, reportCtx context.Context, images []imagelist.Image, status *nodeStatus) {
+	logger, timing := f.logger, f.config.Timing
+	// As in Run, waiting for a pull slot does not count against the overall timeout.
+	slot := acquirePullSlot(ctx, logger, f.config.Coordination)
+	pullCtx, cancelPulls := context.WithTimeout(ctx, timing.OverallTimeout)
+	defer cancelPulls()
+	reportCtx, cancelReport := context.WithCancel(reportCtx)
+	defer cancelReport()
+
+	metricsSink := f.startMetricsSink(reportCtx)
+	var failures sync.Map // map[pullTarget]pullerrors.Class
+	plan := f.planJobs(pullCtx, images, &status.results, metricsSink.Chan())
+	status.storeResolvedDigests(plan.resolvedDigests)
+	jobs := plan.jobs
+	p := f.newPuller(metricsSink.Chan(), &status.results, &failures)
+	pulls := f.preflightDiskSpace(pullCtx, plan, p)
+	logger.Info("starting to pull new images", "jobs", len(pulls))
+	runPullWorkers(pullCtx, f.config.MaxParallelPulls, f.registryLimiter, pulls, p.pullImage)
+	failed := 0
+	failures.Range(func(_, _ any) bool {
+		failed++
+		return true
+	})
+	logger.Info("pulling new images finished", "jobs", len(jobs), "failed", failed, "error", context.Cause(pullCtx))
+
+	reportingTimer := time.AfterFunc(timing.ReportingTimeout, cancelReport)
+	defer reportingTimer.Stop()
+	releasePullSlot(reportCtx, logger, slot)
+	f.sampleImageFsUsage(reportCtx, fsStageAfter, 0)
+	metricsSink.Await()
+	status.patchNodeLabels(reportCtx, logger)
+	if err := nodelabels.RecordPulledImages(reportCtx, logger, pulledImages(&status.results), nil); err != nil {
+		logger.Error("failed to record pulled images", "error", err)
+	}
+	if err := nodelabels.RecordResolvedDigests(reportCtx, logger, plan.resolvedDigests); err != nil {
+		logger.Error("failed to record resolved digests", "error", err)
+	}
+}
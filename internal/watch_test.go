@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stackrox/image-prefetcher/internal/credentialprovider"
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+	"github.com/stackrox/image-prefetcher/internal/registrylimits"
+
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	criV1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+func TestImageQueue(t *testing.T) {
+	queue := newImageQueue()
+	assert.Empty(t, queue.take())
+	queue.add()
+	assert.Empty(t, queue.ready)
+	queue.add(imagelist.FromNames("a", "b")...)
+	queue.add(imagelist.Image{Name: "docker.io/library/b:latest", Priority: 1}, imagelist.Image{Name: "docker.io/library/c:latest"})
+	assert.Len(t, queue.ready, 1)
+	assert.Equal(t, imagelist.FromNames("a", "b", "c"), queue.take())
+	assert.Empty(t, queue.take())
+}
+
+func TestImageListWatcher(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "images.txt")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(fileName, []byte(content), 0o600))
+	}
+	w := &imageListWatcher{fileName: fileName}
+
+	write("a\nb pullPolicy=IfNotPresent\n")
+	added, err := w.poll()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docker.io/library/a:latest", "docker.io/library/b:latest"}, names(added))
+
+	added, err = w.poll()
+	require.NoError(t, err)
+	assert.Empty(t, added, "unchanged file")
+
+	write("b\nc priority=5\na\n")
+	added, err = w.poll()
+	require.NoError(t, err)
+	assert.Equal(t, []imagelist.Image{{Name: "docker.io/library/c:latest", Priority: 5}}, added)
+
+	write("c\nd bogus=1\n")
+	_, err = w.poll()
+	assert.ErrorContains(t, err, "images.txt: line 2")
+
+	write("c\nd\n")
+	added, err = w.poll()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docker.io/library/d:latest"}, names(added), "compared to the last valid list")
+
+	write("c\nd\na\n")
+	added, err = w.poll()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docker.io/library/a:latest"}, names(added), "removed and added again")
+}
+
+func names(images []imagelist.Image) []string {
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	return names
+}
+
+func TestPullQueued(t *testing.T) {
+	client := &fakeImageService{images: map[string]*criV1.Image{}}
+	f := &prefetcher{
+		logger:          slogt.New(t),
+		config:          Config{Timing: testTiming},
+		criClient:       client,
+		kr:              &credentialprovider.BasicDockerKeyring{},
+		registryLimiter: registrylimits.NewLimiter(registrylimits.Config{}),
+	}
+	queue := newImageQueue()
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.pullQueued(ctx, t.Context(), queue, 10*time.Millisecond, &nodeStatus{})
+	}()
+	pulled := func() []string {
+		client.mutex.Lock()
+		defer client.mutex.Unlock()
+		var images []string
+		for _, pull := range client.pulls {
+			images = append(images, pull.GetImage().GetImage())
+		}
+		return images
+	}
+
+	queue.add(imagelist.FromNames("web:v2", "envoy:v2")...)
+	assert.EventuallyWithT(t, func(c *assert.CollectT) {
+		assert.ElementsMatch(c, []string{"docker.io/library/web:v2", "docker.io/library/envoy:v2"}, pulled())
+	}, 5*time.Second, 10*time.Millisecond)
+	queue.add(imagelist.FromNames("web:v3")...)
+	assert.EventuallyWithT(t, func(c *assert.CollectT) {
+		assert.ElementsMatch(c, []string{"docker.io/library/web:v2", "docker.io/library/envoy:v2", "docker.io/library/web:v3"}, pulled())
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
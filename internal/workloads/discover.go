@@ -0,0 +1,63 @@
+package workloads
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Discover returns images used by Deployments, StatefulSets, DaemonSets and CronJobs in the given namespaces,
+// or in all namespaces if none are given, whose labels match the label selector, without duplicates.
+func Discover(ctx context.Context, client kubernetes.Interface, namespaces []string, labelSelector string) ([]string, error) {
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	options := metav1.ListOptions{LabelSelector: labelSelector}
+	var images imageSet
+	addAll := func(specs []*corev1.PodSpec) {
+		for _, spec := range specs {
+			for _, image := range PodSpecImages(spec) {
+				images.add(image)
+			}
+		}
+	}
+	for _, namespace := range namespaces {
+		deployments, err := client.AppsV1().Deployments(namespace).List(ctx, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list deployments: %w", err)
+		}
+		addAll(podSpecs(deployments.Items))
+		statefulSets, err := client.AppsV1().StatefulSets(namespace).List(ctx, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list statefulsets: %w", err)
+		}
+		addAll(podSpecs(statefulSets.Items))
+		daemonSets, err := client.AppsV1().DaemonSets(namespace).List(ctx, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list daemonsets: %w", err)
+		}
+		addAll(podSpecs(daemonSets.Items))
+		cronJobs, err := client.BatchV1().CronJobs(namespace).List(ctx, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list cronjobs: %w", err)
+		}
+		addAll(podSpecs(cronJobs.Items))
+	}
+	return images.list, nil
+}
+
+// podSpecs returns pod specs of the given workload objects.
+func podSpecs[T any, PT interface {
+	*T
+	runtime.Object
+}](items []T) []*corev1.PodSpec {
+	specs := make([]*corev1.PodSpec, 0, len(items))
+	for i := range items {
+		specs = append(specs, PodSpec(PT(&items[i])))
+	}
+	return specs
+}
@@ -0,0 +1,60 @@
+package workloads
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func podTemplate(images ...string) corev1.PodTemplateSpec {
+	var template corev1.PodTemplateSpec
+	for _, image := range images {
+		template.Spec.Containers = append(template.Spec.Containers, corev1.Container{Image: image})
+	}
+	return template
+}
+
+func objectMeta(namespace, name string, labels map[string]string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels}
+}
+
+func TestDiscover(t *testing.T) {
+	team := map[string]string{"team": "a"}
+	client := fake.NewClientset(
+		&appsv1.Deployment{ObjectMeta: objectMeta("shop", "web", team), Spec: appsv1.DeploymentSpec{Template: podTemplate("web:v1", "envoy:v1")}},
+		&appsv1.StatefulSet{ObjectMeta: objectMeta("shop", "db", nil), Spec: appsv1.StatefulSetSpec{Template: podTemplate("postgres:16")}},
+		&appsv1.DaemonSet{ObjectMeta: objectMeta("infra", "agent", team), Spec: appsv1.DaemonSetSpec{Template: podTemplate("agent:v1", "envoy:v1")}},
+		&batchv1.CronJob{ObjectMeta: objectMeta("shop", "report", team), Spec: batchv1.CronJobSpec{JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: podTemplate("report:v2")}}}},
+		&corev1.Pod{ObjectMeta: objectMeta("shop", "bare-pods-are-ignored", team), Spec: corev1.PodSpec{Containers: []corev1.Container{{Image: "debug"}}}},
+	)
+	tests := map[string]struct {
+		namespaces    []string
+		labelSelector string
+		expected      []string
+	}{
+		"all namespaces": {
+			expected: []string{"web:v1", "envoy:v1", "postgres:16", "agent:v1", "report:v2"},
+		},
+		"some namespaces": {
+			namespaces: []string{"shop", "empty"},
+			expected:   []string{"web:v1", "envoy:v1", "postgres:16", "report:v2"},
+		},
+		"label selector": {
+			labelSelector: "team=a",
+			expected:      []string{"web:v1", "envoy:v1", "agent:v1", "report:v2"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			images, err := Discover(t.Context(), client, test.namespaces, test.labelSelector)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, test.expected, images)
+		})
+	}
+}
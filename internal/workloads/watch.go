@@ -0,0 +1,110 @@
+package workloads
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Watcher notices images which start being used by Deployments, StatefulSets, DaemonSets and CronJobs,
+// for example when a pod template image changes ahead of a rollout.
+type Watcher struct {
+	factories []informers.SharedInformerFactory
+	// handlersSynced tell whether event handlers have processed the initial list of their informer.
+	handlersSynced []cache.InformerSynced
+	onNew          func(images []string)
+
+	mu sync.Mutex
+	// known holds normalized images used by watched workloads at any point since the watcher started.
+	known map[string]struct{}
+}
+
+// NewWatcher creates a watcher of workloads in the given namespaces, or in all namespaces if none are given,
+// whose labels match the label selector. It calls onNew with images not used by any of them before.
+// Calls are sequential per kind of workload, so onNew should not block for long.
+func NewWatcher(client kubernetes.Interface, namespaces []string, labelSelector string, onNew func(images []string)) *Watcher {
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	w := &Watcher{
+		onNew: onNew,
+		known: map[string]struct{}{},
+	}
+	tweak := func(options *metav1.ListOptions) { options.LabelSelector = labelSelector }
+	for _, namespace := range namespaces {
+		factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithNamespace(namespace), informers.WithTweakListOptions(tweak))
+		for _, informer := range []cache.SharedIndexInformer{
+			factory.Apps().V1().Deployments().Informer(),
+			factory.Apps().V1().StatefulSets().Informer(),
+			factory.Apps().V1().DaemonSets().Informer(),
+			factory.Batch().V1().CronJobs().Informer(),
+		} {
+			// Cannot fail before the informer is started.
+			registration, _ := informer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+				AddFunc:    w.handle,
+				UpdateFunc: func(_, obj any) { w.handle(obj, false) },
+			})
+			w.handlersSynced = append(w.handlersSynced, registration.HasSynced)
+		}
+		w.factories = append(w.factories, factory)
+	}
+	return w
+}
+
+// Start starts watching until ctx is done, and waits until workloads existing at that point are known.
+// Images used by these are not reported as new.
+func (w *Watcher) Start(ctx context.Context) error {
+	for _, factory := range w.factories {
+		factory.Start(ctx.Done())
+	}
+	go func() {
+		<-ctx.Done()
+		for _, factory := range w.factories {
+			factory.Shutdown()
+		}
+	}()
+	for _, factory := range w.factories {
+		for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+			if !synced {
+				return fmt.Errorf("failed to list workloads of type %s: %w", informerType, ctx.Err())
+			}
+		}
+	}
+	// Informer stores sync before their handlers are done with the initial list. Until all handlers are,
+	// a workload added since would be reported as new even if its images are used by an existing one.
+	if !cache.WaitForCacheSync(ctx.Done(), w.handlersSynced...) {
+		return fmt.Errorf("failed to process existing workloads: %w", ctx.Err())
+	}
+	return nil
+}
+
+func (w *Watcher) handle(obj any, isInInitialList bool) {
+	object, ok := obj.(runtime.Object)
+	if !ok {
+		return
+	}
+	var newImages []string
+	w.mu.Lock()
+	for _, image := range PodSpecImages(PodSpec(object)) {
+		normalized := imagelist.Normalize(image)
+		if _, known := w.known[normalized]; known {
+			continue
+		}
+		w.known[normalized] = struct{}{}
+		if !isInInitialList {
+			newImages = append(newImages, image)
+		}
+	}
+	w.mu.Unlock()
+	if len(newImages) > 0 {
+		w.onNew(newImages)
+	}
+}
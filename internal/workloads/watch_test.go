@@ -0,0 +1,80 @@
+package workloads
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestWatcher(t *testing.T) {
+	team := map[string]string{"team": "a"}
+	client := fake.NewClientset(
+		&appsv1.Deployment{ObjectMeta: objectMeta("shop", "web", team), Spec: appsv1.DeploymentSpec{Template: podTemplate("web:v1", "envoy:v1")}},
+		&appsv1.StatefulSet{ObjectMeta: objectMeta("shop", "db", team), Spec: appsv1.StatefulSetSpec{Template: podTemplate("postgres:16")}},
+	)
+	// Objects created between listing and watching would be missed by the fake clientset, so wait for all watches.
+	// Unlike the API server, the fake clientset also does not filter watch events by labels.
+	watches := make(chan struct{}, 4)
+	client.PrependWatchReactor("*", func(action clienttesting.Action) (bool, watch.Interface, error) {
+		w, err := client.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+		selector := action.(clienttesting.WatchAction).GetWatchRestrictions().Labels
+		watches <- struct{}{}
+		return true, watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+			object, err := meta.Accessor(event.Object)
+			return event, err == nil && selector.Matches(labels.Set(object.GetLabels()))
+		}), nil
+	})
+	reported := make(chan []string, 10)
+	watcher := NewWatcher(client, []string{"shop"}, "team=a", func(images []string) { reported <- images })
+	require.NoError(t, watcher.Start(t.Context()))
+	for range 4 {
+		<-watches
+	}
+
+	deployments := client.AppsV1().Deployments("shop")
+	web, err := deployments.Get(t.Context(), "web", metav1.GetOptions{})
+	require.NoError(t, err)
+	web.Spec.Template = podTemplate("web:v2", "envoy:v1")
+	_, err = deployments.Update(t.Context(), web, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web:v2"}, receive(t, reported))
+
+	_, err = deployments.Create(t.Context(), &appsv1.Deployment{ObjectMeta: objectMeta("shop", "other-team", nil), Spec: appsv1.DeploymentSpec{Template: podTemplate("ignored:v1")}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = client.AppsV1().DaemonSets("elsewhere").Create(t.Context(), &appsv1.DaemonSet{ObjectMeta: objectMeta("elsewhere", "agent", team), Spec: appsv1.DaemonSetSpec{Template: podTemplate("ignored:v2")}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	cronJob := &batchv1.CronJob{ObjectMeta: objectMeta("shop", "report", team), Spec: batchv1.CronJobSpec{JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: podTemplate("postgres:16", "report:v1", "web:v1")}}}}
+	_, err = client.BatchV1().CronJobs("shop").Create(t.Context(), cronJob, metav1.CreateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"report:v1"}, receive(t, reported))
+
+	select {
+	case images := <-reported:
+		t.Fatalf("unexpected images reported: %v", images)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func receive(t *testing.T, reported <-chan []string) []string {
+	t.Helper()
+	select {
+	case images := <-reported:
+		return images
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for new images")
+		return nil
+	}
+}
@@ -0,0 +1,177 @@
+// Package workloads finds images used by Kubernetes workloads.
+package workloads
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/stackrox/image-prefetcher/internal/imagelist"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// PodSpec returns the pod spec of a workload object, or nil if the object does not have one.
+func PodSpec(obj runtime.Object) *corev1.PodSpec {
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		return &o.Spec
+	case *corev1.PodTemplate:
+		return &o.Template.Spec
+	case *corev1.ReplicationController:
+		if o.Spec.Template != nil {
+			return &o.Spec.Template.Spec
+		}
+	case *appsv1.Deployment:
+		return &o.Spec.Template.Spec
+	case *appsv1.ReplicaSet:
+		return &o.Spec.Template.Spec
+	case *appsv1.StatefulSet:
+		return &o.Spec.Template.Spec
+	case *appsv1.DaemonSet:
+		return &o.Spec.Template.Spec
+	case *batchv1.Job:
+		return &o.Spec.Template.Spec
+	case *batchv1.CronJob:
+		return &o.Spec.JobTemplate.Spec.Template.Spec
+	}
+	return nil
+}
+
+// PodSpecImages returns the images of all containers, init containers, ephemeral containers and image volumes
+// of the pod spec, in this order, without duplicates, even if spelled differently.
+func PodSpecImages(spec *corev1.PodSpec) []string {
+	if spec == nil {
+		return nil
+	}
+	var images imageSet
+	for _, container := range spec.InitContainers {
+		images.add(container.Image)
+	}
+	for _, container := range spec.Containers {
+		images.add(container.Image)
+	}
+	for _, container := range spec.EphemeralContainers {
+		images.add(container.Image)
+	}
+	for _, volume := range spec.Volumes {
+		if volume.Image != nil {
+			images.add(volume.Image.Reference)
+		}
+	}
+	return images.list
+}
+
+// FromManifests returns images used by workloads in multi-document Kubernetes YAML or JSON, without duplicates,
+// in order of first appearance. Objects of kinds without a pod template, including unknown kinds, are ignored.
+func FromManifests(r io.Reader) ([]string, error) {
+	var images imageSet
+	reader := yaml.NewYAMLReader(bufio.NewReader(r))
+	for i := 1; ; i++ {
+		document, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return images.list, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		if err := images.addManifest(document); err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+	}
+}
+
+// FromManifestFiles returns images used by workloads in the given manifest files, without duplicates.
+// A file name of - means standard input.
+func FromManifestFiles(fileNames ...string) ([]string, error) {
+	var images imageSet
+	for _, fileName := range fileNames {
+		fileImages, err := fromManifestFile(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+		for _, image := range fileImages {
+			images.add(image)
+		}
+	}
+	return images.list, nil
+}
+
+func fromManifestFile(fileName string) ([]string, error) {
+	if fileName == "-" {
+		return FromManifests(os.Stdin)
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return FromManifests(f)
+}
+
+func (s *imageSet) addManifest(document []byte) error {
+	if isEmptyDocument(document) {
+		return nil
+	}
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(document, nil, nil)
+	if runtime.IsNotRegisteredError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if list, ok := obj.(*corev1.List); ok {
+		for i, item := range list.Items {
+			if err := s.addManifest(item.Raw); err != nil {
+				return fmt.Errorf("list item %d: %w", i+1, err)
+			}
+		}
+		return nil
+	}
+	for _, image := range PodSpecImages(PodSpec(obj)) {
+		s.add(image)
+	}
+	return nil
+}
+
+// isEmptyDocument returns whether the YAML document has nothing but blank lines and comments.
+func isEmptyDocument(document []byte) bool {
+	for line := range bytes.Lines(document) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 && !bytes.HasPrefix(line, []byte("#")) {
+			return false
+		}
+	}
+	return true
+}
+
+// imageSet accumulates image names without duplicates, in order of first appearance.
+// Names referring to the same image once normalized, such as nginx and docker.io/library/nginx:latest, are
+// duplicates, and the first spelling is kept.
+type imageSet struct {
+	seen map[string]bool
+	list []string
+}
+
+func (s *imageSet) add(image string) {
+	if image == "" {
+		return
+	}
+	normalized := imagelist.Normalize(image)
+	if s.seen[normalized] {
+		return
+	}
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	s.seen[normalized] = true
+	s.list = append(s.list, image)
+}
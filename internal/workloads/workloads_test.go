@@ -0,0 +1,156 @@
+package workloads
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const manifests = `# rendered by helm
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+  - port: 80
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: migrate
+        image: quay.io/example/migrate:v1
+      containers:
+      - name: web
+        image: quay.io/example/web:v1
+      - name: proxy
+        image: envoyproxy/envoy:v1.30
+      volumes:
+      - name: models
+        image:
+          reference: quay.io/example/models:v3
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: report
+spec:
+  schedule: "@daily"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: report
+            image: quay.io/example/report:v2
+          - name: proxy
+            image: envoyproxy/envoy:v1.30
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: unknown-kinds-are-ignored
+spec:
+  image: quay.io/example/widget:v1
+---
+{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "debug"}, "spec": {"containers": [{"name": "main", "image": "busybox"}], "ephemeralContainers": [{"name": "debugger", "image": "nicolaka/netshoot"}]}}
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: apps/v1
+  kind: DaemonSet
+  metadata:
+    name: agent
+  spec:
+    template:
+      spec:
+        containers:
+        - name: agent
+          image: quay.io/example/agent:v1
+- apiVersion: apps/v1
+  kind: StatefulSet
+  metadata:
+    name: db
+  spec:
+    template:
+      spec:
+        containers:
+        - name: db
+          image: postgres:16
+- apiVersion: batch/v1
+  kind: Job
+  metadata:
+    name: once
+  spec:
+    template:
+      spec:
+        containers:
+        - name: once
+          image: quay.io/example/web:v1
+        - name: same-image-spelled-differently
+          image: docker.io/library/postgres:16
+`
+
+func TestFromManifests(t *testing.T) {
+	images, err := FromManifests(strings.NewReader(manifests))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"quay.io/example/migrate:v1",
+		"quay.io/example/web:v1",
+		"envoyproxy/envoy:v1.30",
+		"quay.io/example/models:v3",
+		"quay.io/example/report:v2",
+		"busybox",
+		"nicolaka/netshoot",
+		"quay.io/example/agent:v1",
+		"postgres:16",
+	}, images)
+}
+
+func TestFromManifestsErrors(t *testing.T) {
+	tests := map[string]struct {
+		input       string
+		expectedErr string
+	}{
+		"missing kind": {
+			input:       "apiVersion: v1\nkind: Pod\n---\nmetadata:\n  name: x\n",
+			expectedErr: "document 2: ",
+		},
+		"malformed document": {
+			input:       "apiVersion: apps/v1\nkind: Deployment\nspec: [",
+			expectedErr: "document 1: ",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := FromManifests(strings.NewReader(test.input))
+			assert.ErrorContains(t, err, test.expectedErr)
+		})
+	}
+}
+
+func TestFromManifestFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.yaml")
+	second := filepath.Join(dir, "second.yaml")
+	require.NoError(t, os.WriteFile(first, []byte("apiVersion: v1\nkind: Pod\nspec:\n  containers:\n  - image: nginx\n"), 0o644))
+	require.NoError(t, os.WriteFile(second, []byte("apiVersion: v1\nkind: Pod\nspec:\n  containers:\n  - image: docker.io/library/nginx:latest\n  - image: redis\n"), 0o644))
+
+	images, err := FromManifestFiles(first, second)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"nginx", "redis"}, images)
+
+	_, err = FromManifestFiles(filepath.Join(dir, "missing.yaml"))
+	assert.ErrorContains(t, err, "missing.yaml: ")
+}